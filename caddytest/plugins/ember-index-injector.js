@@ -21,7 +21,7 @@ function baseTag(){
 };
 
 var file = process.argv[3];
-var env = 'development';
+var env = process.argv[4] || 'development';
 var envFn = require('../config/environment.js');
 var ENV = envFn(env);
 var BASE_TAG = baseTag();
@@ -0,0 +1,162 @@
+// Package devcaddy builds a project's files through its plugins, serves
+// them and reloads the browser when they change. The devcaddy command in
+// cmd/devcaddy is built on it, and so can be tests that need a dev server.
+package devcaddy
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/monocle/devcaddy/devcaddy/lib"
+)
+
+// Caddy runs a project and the server for it. The server settings come
+// from the config's "server", an empty port meaning any free port.
+type Caddy struct {
+	Config  *lib.Config
+	Project *lib.Project
+	Server  *lib.Server
+
+	// ConfigPath is the config file to reload when it is saved. It is
+	// not watched when empty.
+	ConfigPath string
+
+	// AutoPort moves the server to the next free port when the configured
+	// one is busy.
+	AutoPort bool
+
+	ln       net.Listener
+	ready    chan struct{}
+	stopping chan struct{} // closed once Stop is called
+	done     chan struct{}
+	once     sync.Once
+	mu       sync.Mutex
+	err      error
+}
+
+// New starts the plugins and watchers of c without scanning any files
+// yet. Nothing is logged unless c.Log is set.
+func New(c *lib.Config) (*Caddy, error) {
+	p, err := lib.NewProject(c)
+	if err != nil {
+		return nil, err
+	}
+
+	s := lib.NewServer(p.Store)
+	s.Log = c.Log
+	s.AssetRoot = c.Server.AssetRoot
+	if err := s.SetProxy(c.Server.Proxy); err != nil {
+		s.Close()
+		p.Close()
+		return nil, err
+	}
+
+	return &Caddy{
+		Config:   c,
+		Project:  p,
+		Server:   s,
+		ready:    make(chan struct{}),
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Start listens on the configured port, then builds the project and
+// serves it in the background. Requests wait for the first build to
+// finish. The caddy stops when ctx is done.
+func (c *Caddy) Start(ctx context.Context) error {
+	port := c.Config.Server.Port
+	if port == "" {
+		port = "0"
+	}
+
+	ln, err := lib.Listen(c.Config.Server.Host, port, c.AutoPort)
+	if err != nil {
+		return err
+	}
+	c.ln = ln
+
+	go func() {
+		c.Project.Build()
+
+		// the build was cut short, it is not ready
+		select {
+		case <-c.stopping:
+			return
+		default:
+		}
+
+		if c.ConfigPath != "" {
+			if err := c.Project.WatchConfig(c.ConfigPath); err != nil {
+				c.Config.Log.PrintC("error", "Unable to watch "+c.ConfigPath+", it won't be reloaded.\n"+err.Error())
+			}
+		}
+		close(c.ready)
+
+		if err := c.Server.Serve(ln); err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+		}
+		c.Stop()
+	}()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Stop()
+		case <-c.done:
+		}
+	}()
+	return nil
+}
+
+// Stop closes the server, then the watchers and the plugins, killing the
+// commands still running. It returns once the plugin processes have
+// exited and is safe to call more than once.
+func (c *Caddy) Stop() {
+	c.once.Do(func() {
+		close(c.stopping)
+		c.Config.Log.PrintC("server", "shutting down")
+		c.Server.Close()
+		if c.ln != nil {
+			c.ln.Close()
+		}
+		c.Project.Close()
+		close(c.done)
+	})
+}
+
+// Ready is closed once the first build is in the store. It is never
+// closed if the caddy is stopped before then.
+func (c *Caddy) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Done is closed once the caddy has stopped.
+func (c *Caddy) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err is the error that stopped the server, if any.
+func (c *Caddy) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Addr is the address the server listens on, once started.
+func (c *Caddy) Addr() net.Addr {
+	if c.ln == nil {
+		return nil
+	}
+	return c.ln.Addr()
+}
+
+// Subscribe returns a channel receiving the files updated in the store,
+// until the returned func is called. The channel must be read from for
+// the browsers to be reloaded.
+func (c *Caddy) Subscribe() (<-chan *lib.File, func()) {
+	return c.Server.Subscribe()
+}
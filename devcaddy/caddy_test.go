@@ -0,0 +1,152 @@
+package devcaddy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/monocle/devcaddy/devcaddy/lib"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func newTestCaddy(t *testing.T, root string) *Caddy {
+	c, err := lib.NewConfig([]byte(`{
+		"root": "` + root + `",
+		"server": { "host": "127.0.0.1" },
+		"files": [{ "name": "app.js", "dir": "app", "ext": "js" }]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caddy, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caddy
+}
+
+func get(t *testing.T, url string) string {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCaddy(t *testing.T) {
+	Convey("Given a project", t, func() {
+		root, err := ioutil.TempDir("", "devcaddy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+
+		app := filepath.Join(root, "app")
+		os.Mkdir(app, 0700)
+		ioutil.WriteFile(filepath.Join(app, "a.js"), []byte("var a;"), 0600)
+		ioutil.WriteFile(filepath.Join(app, "b.js"), []byte("var b;"), 0600)
+
+		Convey("It is served on a free port once built, until stopped", func() {
+			for run := 0; run < 2; run++ {
+				caddy := newTestCaddy(t, root)
+				ctx, cancel := context.WithCancel(context.Background())
+
+				So(caddy.Start(ctx), ShouldBeNil)
+				select {
+				case <-caddy.Ready():
+				case <-time.After(5 * time.Second):
+					t.Fatal("the project was not built")
+				}
+
+				url := "http://" + caddy.Addr().String()
+				So(get(t, url+"/assets/app.js"), ShouldEqual, "var a;\nvar b;")
+
+				cancel()
+				select {
+				case <-caddy.Done():
+				case <-time.After(5 * time.Second):
+					t.Fatal("the caddy did not stop")
+				}
+
+				So(caddy.Err(), ShouldBeNil)
+				_, err := http.Get(url)
+				So(err, ShouldNotBeNil)
+			}
+		})
+
+		Convey("Stopping it during the first build doesn't wait for the build", func() {
+			started := filepath.Join(root, "started")
+			c, err := lib.NewConfig([]byte(`{
+				"root": "` + root + `",
+				"server": { "host": "127.0.0.1" },
+				"plugins": [{ "name": "slow", "command": "sh", "args": "-c 'touch ` + started + `; sleep 600' {{fileName}}" }],
+				"files": [{ "name": "app.js", "dir": "app", "ext": "js", "plugins": ["slow"] }]
+			}`))
+			So(err, ShouldBeNil)
+			caddy, err := New(c)
+			So(err, ShouldBeNil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			So(caddy.Start(ctx), ShouldBeNil)
+			for {
+				if _, err := os.Stat(started); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			cancel()
+			select {
+			case <-caddy.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("the caddy did not stop")
+			}
+
+			So(caddy.Err(), ShouldBeNil)
+			select {
+			case <-caddy.Ready():
+				t.Fatal("an unfinished build is not ready")
+			default:
+			}
+		})
+
+		Convey("Subscribers receive the files updated in the store", func() {
+			caddy := newTestCaddy(t, root)
+			defer caddy.Stop()
+
+			So(caddy.Start(context.Background()), ShouldBeNil)
+			<-caddy.Ready()
+
+			updates, unsubscribe := caddy.Subscribe()
+			defer unsubscribe()
+
+			name := filepath.Join(app, "a.js")
+			ioutil.WriteFile(name, []byte("var c;"), 0600)
+
+			timeout := time.After(5 * time.Second)
+			for {
+				select {
+				case f := <-updates:
+					if f.Name != name {
+						continue
+					}
+					So(f.Content, ShouldEqual, "var c;")
+				case <-timeout:
+					t.Fatal("no update was received")
+				}
+				break
+			}
+		})
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/monocle/devcaddy/devcaddy/lib"
+)
+
+const USAGE = `Usage: devcaddy [command] [flags]
+
+Commands:
+  serve    build the project and start the development server (default)
+  check    report every problem in the config file
+  init     write a starter config for the project in the current folder
+  cache    "cache stats" describes the cached plugin outputs, "cache clear"
+           removes them
+  help     show this message
+
+Run "devcaddy <command> -h" for the flags of a command.
+`
+
+// Env variables used when a flag is not given on the command line.
+const (
+	ENV_CONFIG     = "DEVCADDY_CONFIG"
+	ENV_PORT       = "DEVCADDY_PORT"
+	ENV_HOST       = "DEVCADDY_HOST"
+	ENV_PROXY      = "DEVCADDY_PROXY"
+	ENV_ASSET_ROOT = "DEVCADDY_ASSET_ROOT"
+	ENV_PROFILE    = "DEVCADDY_PROFILE"
+)
+
+const (
+	DEFAULT_PORT       = "4200"
+	DEFAULT_ASSET_ROOT = "assets"
+)
+
+type configOptions struct {
+	Config  string
+	Profile string
+}
+
+type initOptions struct {
+	Config string
+	Force  bool
+}
+
+type cacheOptions struct {
+	configOptions
+	Action string // "stats" or "clear"
+}
+
+type serveOptions struct {
+	configOptions
+	Port      string
+	Host      string
+	Proxy     string
+	AssetRoot string
+
+	// AutoPort is true when no port was requested explicitly, in which
+	// case the server may move to the next free port.
+	AutoPort bool
+}
+
+// splitCommand returns the subcommand and its arguments. Running devcaddy
+// without a command, or with only flags, is the same as "serve".
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 || (args[0] != "" && args[0][0] == '-') {
+		return "serve", args
+	}
+	return args[0], args[1:]
+}
+
+func (opts *configOptions) addFlags(fs *flag.FlagSet, getenv func(string) string) {
+	fs.StringVar(&opts.Config, "config", envOr(getenv, ENV_CONFIG, "devcaddy.json"), "path to the config file ($"+ENV_CONFIG+")")
+	fs.StringVar(&opts.Profile, "profile", envOr(getenv, ENV_PROFILE, ""), "config profile to apply, ie. development or production ($"+ENV_PROFILE+")")
+}
+
+// parseServeFlags parses the flags for the serve command. Flags take
+// precedence over env variables, which take precedence over the config
+// file and then defaults. See applyServerConfig.
+func parseServeFlags(args []string, getenv func(string) string, output io.Writer) (*serveOptions, error) {
+	opts := serveOptions{}
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	opts.configOptions.addFlags(fs, getenv)
+	fs.StringVar(&opts.Port, "port", envOr(getenv, ENV_PORT, ""), "port to serve on, defaults to "+DEFAULT_PORT+" or the next free port ($"+ENV_PORT+")")
+	fs.StringVar(&opts.Host, "host", envOr(getenv, ENV_HOST, ""), "host to bind to, defaults to all interfaces ($"+ENV_HOST+")")
+	fs.StringVar(&opts.Proxy, "proxy", envOr(getenv, ENV_PROXY, ""), "URL to proxy requests that are not in the store ($"+ENV_PROXY+")")
+	fs.StringVar(&opts.AssetRoot, "asset-root", envOr(getenv, ENV_ASSET_ROOT, ""), "URL path assets are served from, defaults to "+DEFAULT_ASSET_ROOT+" ($"+ENV_ASSET_ROOT+")")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	return &opts, nil
+}
+
+// applyServerConfig fills in the settings that were not given as flags or
+// env variables from the config's "server", then from the defaults.
+func (opts *serveOptions) applyServerConfig(sc lib.ServerConfig) {
+	set := func(opt *string, fromConfig, def string) {
+		if *opt == "" {
+			*opt = fromConfig
+		}
+		if *opt == "" {
+			*opt = def
+		}
+	}
+
+	if opts.Port == "" && sc.Port == "" {
+		opts.AutoPort = true
+	}
+
+	set(&opts.Port, sc.Port, DEFAULT_PORT)
+	set(&opts.Host, sc.Host, "")
+	set(&opts.Proxy, sc.Proxy, "")
+	set(&opts.AssetRoot, sc.AssetRoot, DEFAULT_ASSET_ROOT)
+}
+
+// parseCheckFlags parses the flags for the check command.
+func parseCheckFlags(args []string, getenv func(string) string, output io.Writer) (*configOptions, error) {
+	opts := configOptions{}
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.SetOutput(output)
+	opts.addFlags(fs, getenv)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return &opts, nil
+}
+
+// parseInitFlags parses the flags for the init command.
+func parseInitFlags(args []string, getenv func(string) string, output io.Writer) (*initOptions, error) {
+	opts := initOptions{}
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&opts.Config, "config", envOr(getenv, ENV_CONFIG, "devcaddy.json"), "path of the config file to write ($"+ENV_CONFIG+")")
+	fs.BoolVar(&opts.Force, "force", false, "overwrite an existing config file")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return &opts, nil
+}
+
+// parseCacheFlags parses the action and flags for the cache command.
+func parseCacheFlags(args []string, getenv func(string) string, output io.Writer) (*cacheOptions, error) {
+	opts := cacheOptions{}
+	fs := flag.NewFlagSet("cache", flag.ContinueOnError)
+	fs.SetOutput(output)
+	opts.addFlags(fs, getenv)
+
+	if len(args) > 0 && (args[0] == "" || args[0][0] != '-') {
+		opts.Action, args = args[0], args[1:]
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if opts.Action != "stats" && opts.Action != "clear" {
+		return nil, fmt.Errorf("expected \"cache stats\" or \"cache clear\"")
+	}
+
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	return &opts, nil
+}
+
+func envOr(getenv func(string) string, key, def string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, USAGE)
+}
+
+func exitWithUsage(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+	usage(os.Stderr)
+	os.Exit(2)
+}
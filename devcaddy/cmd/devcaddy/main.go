@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"github.com/monocle/devcaddy/devcaddy"
+	"github.com/monocle/devcaddy/devcaddy/lib"
+)
+
+func main() {
+	log.SetFlags(log.Ltime | log.Lshortfile)
+
+	cmd, args := splitCommand(os.Args[1:])
+
+	switch cmd {
+	case "serve":
+		opts, err := parseServeFlags(args, os.Getenv, os.Stderr)
+		if err == flag.ErrHelp {
+			return
+		}
+		if err != nil {
+			exitWithUsage(err.Error())
+		}
+		serve(opts)
+	case "check":
+		opts, err := parseCheckFlags(args, os.Getenv, os.Stderr)
+		if err == flag.ErrHelp {
+			return
+		}
+		if err != nil {
+			exitWithUsage(err.Error())
+		}
+		os.Exit(check(opts, os.Stdout))
+	case "init":
+		opts, err := parseInitFlags(args, os.Getenv, os.Stderr)
+		if err == flag.ErrHelp {
+			return
+		}
+		if err != nil {
+			exitWithUsage(err.Error())
+		}
+		os.Exit(initConfig(opts, ".", os.Stdout))
+	case "cache":
+		opts, err := parseCacheFlags(args, os.Getenv, os.Stderr)
+		if err == flag.ErrHelp {
+			return
+		}
+		if err != nil {
+			exitWithUsage(err.Error())
+		}
+		os.Exit(manageCache(opts, os.Stdout))
+	case "help":
+		usage(os.Stdout)
+	default:
+		exitWithUsage("Unknown command: " + cmd)
+	}
+}
+
+func serve(opts *serveOptions) {
+	c, err := readConfig(&opts.configOptions)
+	exitOnConfigError(err)
+	opts.applyServerConfig(c.Server)
+
+	c.Server = lib.ServerConfig{Port: opts.Port, Host: opts.Host, Proxy: opts.Proxy, AssetRoot: opts.AssetRoot}
+	c.Log = lib.NewLogger(os.Stderr)
+
+	if c.Profile != "" {
+		c.Log.PrintC("server", "using profile "+c.Profile)
+	}
+
+	caddy, err := devcaddy.New(c)
+	exitOnConfigError(err)
+	caddy.ConfigPath = opts.Config
+	caddy.AutoPort = opts.AutoPort
+	caddy.Project.Profile = opts.Profile
+
+	// a second interrupt exits right away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := caddy.Start(ctx); err != nil {
+		log.Fatalln("[error] Unable to listen on port", opts.Port, err)
+	}
+
+	select {
+	case <-caddy.Ready():
+		c.Log.PrintC("server", strconv.Itoa(len(caddy.Project.Store.SortedFileNames()))+" files defined in store")
+	case <-caddy.Done():
+	}
+
+	<-caddy.Done()
+	if err := caddy.Err(); err != nil {
+		log.Fatalln("[error]", err)
+	}
+}
+
+func readConfig(opts *configOptions) (*lib.Config, error) {
+	cfg, err := ioutil.ReadFile(opts.Config)
+	if err != nil {
+		return nil, lib.ConfigErrors{{Message: err.Error(), Help: lib.ERROR_CONFIG_FILE}}
+	}
+	return lib.NewConfigForProfile(cfg, opts.Profile)
+}
+
+// check prints every problem in the config file and returns the exit
+// status.
+func check(opts *configOptions, w io.Writer) int {
+	c, err := readConfig(opts)
+	if err != nil {
+		printConfigErrors(w, err)
+		return 1
+	}
+
+	msg := opts.Config + " is valid"
+	if c.Profile != "" {
+		msg += " with profile " + c.Profile
+	}
+	fmt.Fprintln(w, msg)
+	return 0
+}
+
+// initConfig writes a starter config for the project in dir and returns
+// the exit status. An existing config is only replaced with -force.
+func initConfig(opts *initOptions, dir string, w io.Writer) int {
+	path := filepath.Join(dir, opts.Config)
+
+	if _, err := os.Stat(path); err == nil && !opts.Force {
+		fmt.Fprintln(w, lib.Color("error", "[error] "+opts.Config+" already exists, use -force to replace it"))
+		return 1
+	}
+
+	pi := lib.InspectProject(dir)
+	cfg := pi.Config(dir)
+
+	if err := ioutil.WriteFile(path, cfg, 0644); err != nil {
+		fmt.Fprintln(w, lib.Color("error", "[error] "+err.Error()))
+		return 1
+	}
+
+	kind := "project"
+	if pi.EmberCLI {
+		kind = "ember-cli project"
+	}
+	fmt.Fprintf(w, "Wrote %s for %s %q with %d vendor file(s)\n", opts.Config, kind, pi.Name, len(pi.VendorFiles))
+
+	for _, n := range pi.Notes {
+		fmt.Fprintln(w, "* "+n)
+	}
+	return 0
+}
+
+// manageCache prints the stats of or clears the cache of the configured
+// project, and returns the exit status.
+func manageCache(opts *cacheOptions, w io.Writer) int {
+	c, err := readConfig(&opts.configOptions)
+	if err != nil {
+		printConfigErrors(w, err)
+		return 1
+	}
+
+	cache := lib.NewCache(filepath.Join(c.Root, lib.CACHE_DIR))
+	stats, err := cache.Stats()
+	if err != nil {
+		fmt.Fprintln(w, lib.Color("error", "[error] "+err.Error()))
+		return 1
+	}
+
+	if opts.Action == "clear" {
+		if err := cache.Clear(); err != nil {
+			fmt.Fprintln(w, lib.Color("error", "[error] "+err.Error()))
+			return 1
+		}
+		fmt.Fprintf(w, "Removed %d cached output(s) from %s\n", stats.Entries, cache.Dir)
+		return 0
+	}
+
+	fmt.Fprintf(w, "%d cached output(s), %.1f KB in %s\n", stats.Entries, float64(stats.Size)/1024, cache.Dir)
+	return 0
+}
+
+func exitOnConfigError(err error) {
+	if err != nil {
+		printConfigErrors(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// printConfigErrors lists each error followed by the help for each kind
+// of error found.
+func printConfigErrors(w io.Writer, err error) {
+	errs, ok := err.(lib.ConfigErrors)
+	if !ok {
+		fmt.Fprintln(w, lib.Color("error", "[error] "+err.Error()))
+		return
+	}
+
+	helps := []string{}
+	seen := map[string]bool{}
+	for _, e := range errs {
+		fmt.Fprintln(w, lib.Color("error", "[error] "+e.Error()))
+
+		if e.Help != "" && !seen[e.Help] {
+			seen[e.Help] = true
+			helps = append(helps, e.Help)
+		}
+	}
+
+	for _, h := range helps {
+		fmt.Fprint(w, h)
+	}
+	fmt.Fprintf(w, "\n%d problem(s) found\n", len(errs))
+}
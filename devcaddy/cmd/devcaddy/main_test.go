@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/monocle/devcaddy/devcaddy/lib"
+)
+
+const cfg = `
+{
+    "plugins": [
+        {
+            "name": "transpile-js",
+            "command": "echo",
+            "args": "-n {{fileContent}}1"
+        },
+        {
+            "name": "template",
+            "command": "echo",
+            "args": "-n {{fileContent}}2"
+        },
+        {
+            "name": "silent",
+            "command": "echo",
+            "noOutput": true
+        },
+        {
+            "name": "lint",
+            "command": "echo",
+            "logOnly": true
+        }
+    ],
+    "watch": [
+        {
+            "dir": "app/templates",
+            "ext": "hbs",
+            "plugins": ["template", "transpile-js"]
+        }
+    ],
+    "files": [
+        {
+            "name": "app.js",
+            "dir": "app",
+            "ext": "js",
+            "plugins": ["transpile-js"],
+            "sidePlugins": ["silent", "lint"]
+        },
+        {
+            "name": "vendor.js",
+            "dir": "vendor",
+            "files": ["bar/index.js", "baz/main.js"]
+        },
+        {
+            "name": "index.html",
+            "dir": "app",
+            "files": ["index.html"]
+        }
+    ]
+}
+`
+
+func testEnv(env map[string]string) func(string) string {
+	return func(key string) string {
+		return env[key]
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	Convey("No command or flags only defaults to serve", t, func() {
+		cmd, args := splitCommand([]string{})
+		So(cmd, ShouldEqual, "serve")
+		So(len(args), ShouldEqual, 0)
+
+		cmd, args = splitCommand([]string{"--port", "3000"})
+		So(cmd, ShouldEqual, "serve")
+		So(len(args), ShouldEqual, 2)
+	})
+
+	Convey("The first argument is the command", t, func() {
+		cmd, args := splitCommand([]string{"serve", "--port", "3000"})
+		So(cmd, ShouldEqual, "serve")
+		So(args[0], ShouldEqual, "--port")
+
+		cmd, _ = splitCommand([]string{""})
+		So(cmd, ShouldEqual, "")
+	})
+}
+
+func TestParseServeFlags(t *testing.T) {
+	Convey("Given serve flags", t, func() {
+		out := &bytes.Buffer{}
+
+		Convey("Defaults are used when nothing is given", func() {
+			opts, err := parseServeFlags([]string{}, testEnv(nil), out)
+			So(err, ShouldBeNil)
+			opts.applyServerConfig(lib.ServerConfig{})
+
+			So(opts.Config, ShouldEqual, "devcaddy.json")
+			So(opts.Profile, ShouldEqual, "")
+			So(opts.Port, ShouldEqual, "4200")
+			So(opts.AutoPort, ShouldBeTrue)
+			So(opts.Host, ShouldEqual, "")
+			So(opts.Proxy, ShouldEqual, "")
+			So(opts.AssetRoot, ShouldEqual, "assets")
+		})
+
+		Convey("Flags are parsed", func() {
+			opts, err := parseServeFlags([]string{
+				"--config", "other.json",
+				"--profile", "production",
+				"--port", "3000",
+				"--host", "127.0.0.1",
+				"--proxy", "http://localhost:3001",
+				"--asset-root", "static",
+			}, testEnv(nil), out)
+
+			So(err, ShouldBeNil)
+			opts.applyServerConfig(lib.ServerConfig{Port: "5000", AssetRoot: "public"})
+
+			So(opts.Config, ShouldEqual, "other.json")
+			So(opts.Profile, ShouldEqual, "production")
+			So(opts.Port, ShouldEqual, "3000")
+			So(opts.AutoPort, ShouldBeFalse)
+			So(opts.Host, ShouldEqual, "127.0.0.1")
+			So(opts.Proxy, ShouldEqual, "http://localhost:3001")
+			So(opts.AssetRoot, ShouldEqual, "static")
+		})
+
+		Convey("Env variables are used when a flag is not given", func() {
+			env := testEnv(map[string]string{
+				ENV_PORT:  "5000",
+				ENV_PROXY: "http://localhost:3001",
+			})
+			opts, err := parseServeFlags([]string{"--proxy", "http://localhost:9000"}, env, out)
+
+			So(err, ShouldBeNil)
+			opts.applyServerConfig(lib.ServerConfig{})
+
+			So(opts.Port, ShouldEqual, "5000")
+			So(opts.AutoPort, ShouldBeFalse)
+			So(opts.Proxy, ShouldEqual, "http://localhost:9000")
+		})
+
+		Convey("The config's server settings are used when no flag or env variable is given", func() {
+			env := testEnv(map[string]string{ENV_HOST: "127.0.0.1"})
+			opts, err := parseServeFlags([]string{}, env, out)
+			So(err, ShouldBeNil)
+			opts.applyServerConfig(lib.ServerConfig{
+				Port:      "8080",
+				Host:      "0.0.0.0",
+				AssetRoot: "static",
+			})
+
+			So(opts.Port, ShouldEqual, "8080")
+			So(opts.AutoPort, ShouldBeFalse)
+			So(opts.Host, ShouldEqual, "127.0.0.1")
+			So(opts.AssetRoot, ShouldEqual, "static")
+		})
+
+		Convey("Unknown flags and extra arguments are errors", func() {
+			_, err := parseServeFlags([]string{"--nope"}, testEnv(nil), out)
+			So(err, ShouldNotBeNil)
+
+			_, err = parseServeFlags([]string{"extra"}, testEnv(nil), out)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestReadConfig(t *testing.T) {
+	Convey("The config is read from the given path", t, func() {
+		dir, err := ioutil.TempDir("", "devcaddy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "custom.json")
+		if err := ioutil.WriteFile(path, []byte(cfg), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := readConfig(&configOptions{Config: path})
+		So(err, ShouldBeNil)
+		So(len(c.PluginConfs), ShouldEqual, 4)
+		So(c.Files[0].Name, ShouldEqual, "app.js")
+		So(c.Files[0].Type, ShouldEqual, "merge")
+	})
+}
+
+func TestCheck(t *testing.T) {
+	Convey("Given config files", t, func() {
+		dir, err := ioutil.TempDir("", "devcaddy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		out := &bytes.Buffer{}
+
+		Convey("A valid config passes", func() {
+			path := filepath.Join(dir, "devcaddy.json")
+			ioutil.WriteFile(path, []byte(cfg), 0600)
+
+			So(check(&configOptions{Config: path}, out), ShouldEqual, 0)
+			So(out.String(), ShouldContainSubstring, "is valid")
+		})
+
+		Convey("Profiles are checked", func() {
+			path := filepath.Join(dir, "devcaddy.json")
+			ioutil.WriteFile(path, []byte(`{
+				"plugins": [{ "name": "a", "command": "echo" }],
+				"profiles": {
+					"production": { "files": [{ "name": "app.js", "plugins": ["nope"] }] }
+				}
+			}`), 0600)
+
+			So(check(&configOptions{Config: path}, out), ShouldEqual, 0)
+			So(check(&configOptions{Config: path, Profile: "production"}, out), ShouldEqual, 1)
+			So(out.String(), ShouldContainSubstring, "files[0].plugins[0]")
+
+			So(check(&configOptions{Config: path, Profile: "nope"}, out), ShouldEqual, 1)
+			So(out.String(), ShouldContainSubstring, "Available profiles: production")
+		})
+
+		Convey("Every problem is printed at once", func() {
+			path := filepath.Join(dir, "devcaddy.json")
+			ioutil.WriteFile(path, []byte(`{
+				"plugins": [{ "name": "a", "command": "echo" }],
+				"files": [
+					{ "name": "app.js", "plugins": ["nope"] },
+					{ "name": "app.js", "plugins": ["a"] }
+				]
+			}`), 0600)
+
+			So(check(&configOptions{Config: path}, out), ShouldEqual, 1)
+			So(out.String(), ShouldContainSubstring, "files[0].plugins[0]")
+			So(out.String(), ShouldContainSubstring, "files[1]: watcher")
+			So(out.String(), ShouldContainSubstring, "2 problem(s) found")
+		})
+
+		Convey("A missing config file is reported", func() {
+			So(check(&configOptions{Config: filepath.Join(dir, "nope.json")}, out), ShouldEqual, 1)
+			So(out.String(), ShouldContainSubstring, "Config file was not found")
+		})
+	})
+}
+
+func TestInitConfig(t *testing.T) {
+	Convey("Given a project folder", t, func() {
+		dir, err := ioutil.TempDir("", "devcaddy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		os.MkdirAll(filepath.Join(dir, "app", "styles"), 0700)
+		opts := &initOptions{Config: "devcaddy.json"}
+		out := &bytes.Buffer{}
+
+		Convey("A starter config is written", func() {
+			So(initConfig(opts, dir, out), ShouldEqual, 0)
+			So(out.String(), ShouldContainSubstring, "Wrote devcaddy.json")
+
+			b, err := ioutil.ReadFile(filepath.Join(dir, "devcaddy.json"))
+			So(err, ShouldBeNil)
+			c, err := lib.NewConfig(b)
+			So(err, ShouldBeNil)
+			So(c.Files[0].Name, ShouldEqual, filepath.Base(dir)+".js")
+		})
+
+		Convey("An existing config is kept unless forced", func() {
+			path := filepath.Join(dir, "devcaddy.json")
+			ioutil.WriteFile(path, []byte("{}"), 0600)
+
+			So(initConfig(opts, dir, out), ShouldEqual, 1)
+			So(out.String(), ShouldContainSubstring, "already exists")
+			b, _ := ioutil.ReadFile(path)
+			So(string(b), ShouldEqual, "{}")
+
+			opts.Force = true
+			So(initConfig(opts, dir, out), ShouldEqual, 0)
+			b, _ = ioutil.ReadFile(path)
+			So(string(b), ShouldNotEqual, "{}")
+		})
+	})
+}
+
+func TestManageCache(t *testing.T) {
+	Convey("Given a project with cached outputs", t, func() {
+		dir, err := ioutil.TempDir("", "devcaddy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "devcaddy.json")
+		ioutil.WriteFile(path, []byte(`{ "root": "`+dir+`" }`), 0600)
+
+		c := lib.NewCache(filepath.Join(dir, lib.CACHE_DIR))
+		c.Put(c.Key(&lib.PluginConfig{}, &lib.File{Name: "a.js"}), &lib.File{Name: "a.js", Content: "a"})
+		out := &bytes.Buffer{}
+
+		Convey("The action is required", func() {
+			_, err := parseCacheFlags([]string{"-config", path}, testEnv(nil), out)
+			So(err, ShouldNotBeNil)
+
+			_, err = parseCacheFlags([]string{""}, testEnv(nil), out)
+			So(err, ShouldNotBeNil)
+
+			opts, err := parseCacheFlags([]string{"stats", "-config", path}, testEnv(nil), out)
+			So(err, ShouldBeNil)
+			So(opts.Action, ShouldEqual, "stats")
+		})
+
+		Convey("Stats and clear report the entries", func() {
+			opts := &cacheOptions{configOptions{Config: path}, "stats"}
+			So(manageCache(opts, out), ShouldEqual, 0)
+			So(out.String(), ShouldContainSubstring, "1 cached output(s)")
+
+			opts.Action = "clear"
+			So(manageCache(opts, out), ShouldEqual, 0)
+			So(out.String(), ShouldContainSubstring, "Removed 1 cached output(s)")
+
+			s, _ := c.Stats()
+			So(s.Entries, ShouldEqual, 0)
+		})
+	})
+}
@@ -0,0 +1,177 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// decodeArgs reads the "args" of the config, a string split like a shell
+// would or an array of args used as they are.
+func (cfg *PluginConfig) decodeArgs() error {
+	raw := bytes.TrimSpace(cfg.RawArgs)
+	cfg.RawArgs = nil
+
+	var err error
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return nil
+	case raw[0] == '[':
+		err = json.Unmarshal(raw, &cfg.ArgList)
+	default:
+		err = json.Unmarshal(raw, &cfg.Args)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("expected a string or an array of strings but got %s", raw)
+		return &ConfigError{Path: "args", Message: msg, Help: ERROR_PLUGIN_ARGS}
+	}
+	return nil
+}
+
+// parseArgs checks that the command and args can be split, unless they
+// are run by a shell.
+func (cfg *PluginConfig) parseArgs() error {
+	if cfg.Shell {
+		if cfg.Transformer != "" || cfg.IsProcess() {
+			return &ConfigError{Path: "shell", Message: "only command plugins run through a shell", Help: ERROR_PLUGIN_ARGS}
+		}
+		return nil
+	}
+
+	if cfg.Transformer == "" {
+		words, err := splitArgs(cfg.Command)
+		if err == nil && len(words) == 0 {
+			err = errors.New("the command is empty")
+		}
+		if err != nil {
+			return &ConfigError{Path: "command", Message: err.Error(), Help: ERROR_PLUGIN_ARGS}
+		}
+	}
+	if _, err := cfg.argWords(); err != nil {
+		return &ConfigError{Path: "args", Message: err.Error(), Help: ERROR_PLUGIN_ARGS}
+	}
+	return nil
+}
+
+// argsText is the args as written, to look for variables in. An array
+// is quoted for the shell.
+func (cfg *PluginConfig) argsText() string {
+	if cfg.ArgList == nil {
+		return cfg.Args
+	}
+	if !cfg.Shell {
+		return strings.Join(cfg.ArgList, " ")
+	}
+
+	words := make([]string, len(cfg.ArgList))
+	for i, arg := range cfg.ArgList {
+		words[i] = shellWord(arg)
+	}
+	return strings.Join(words, " ")
+}
+
+// argWords are the args before variables are replaced.
+func (cfg *PluginConfig) argWords() ([]string, error) {
+	if cfg.ArgList != nil {
+		return append([]string{}, cfg.ArgList...), nil
+	}
+	return splitArgs(cfg.Args)
+}
+
+// splitArgs splits s into words like a POSIX shell, without expanding
+// anything: words are separated by blanks, single quotes keep everything
+// as is, and backslashes escape the next character, or in double quotes
+// one of $ ` " \ or a newline.
+func splitArgs(s string) ([]string, error) {
+	words := []string{}
+	word := &strings.Builder{}
+	inWord := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case c == '\\':
+			i++
+			if i == len(s) {
+				return nil, errors.New("args end with an escaping backslash")
+			}
+			if s[i] != '\n' {
+				word.WriteByte(s[i])
+				inWord = true
+			}
+
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("args have an unterminated single quote")
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, errors.New("args have an unterminated double quote")
+			}
+			inWord = true
+
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// shellWord quotes arg as a single word for sh, leaving its variables to
+// be quoted once they are replaced.
+func shellWord(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+
+	word := &strings.Builder{}
+	last := 0
+	for _, m := range varRegexp.FindAllStringIndex(arg, -1) {
+		if m[0] > last {
+			word.WriteString(shellQuote(arg[last:m[0]]))
+		}
+		word.WriteString(arg[m[0]:m[1]])
+		last = m[1]
+	}
+	if last < len(arg) {
+		word.WriteString(shellQuote(arg[last:]))
+	}
+	return word.String()
+}
+
+// shellQuote quotes s as a single word for sh.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+@%") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPluginArgs(t *testing.T) {
+	Convey("Args are split like a shell would", t, func() {
+		words, err := splitArgs(`  -o 'my dir/out.js'  "a \"b\" \$c" d\ e '' {{fileName}}`)
+		So(err, ShouldBeNil)
+		So(words, ShouldResemble, []string{"-o", "my dir/out.js", `a "b" $c`, "d e", "", "{{fileName}}"})
+
+		_, err = splitArgs(`'oops`)
+		So(err, ShouldNotBeNil)
+		_, err = splitArgs(`"oops`)
+		So(err, ShouldNotBeNil)
+
+		pc := PluginConfig{Command: "cat", Args: `"unterminated`}
+		cerr := pc.Parse().(*ConfigError)
+		So(cerr.Path, ShouldEqual, "args")
+		So(cerr.Help, ShouldEqual, ERROR_PLUGIN_ARGS)
+	})
+
+	Convey("Variables are always a single arg", t, func() {
+		pc := PluginConfig{Command: "echo", Args: "-n {{fileContent}}"}
+		args := pc.InjectedArgs(&File{Name: "a b.js", Content: "x  'y'"})
+		So(args, ShouldResemble, []string{"-n", "x  'y'"})
+	})
+
+	Convey("Args can be an array in the config", t, func() {
+		c, err := NewConfig([]byte(`{"plugins": [
+			{"name": "a", "command": "echo", "args": ["-n", "a  b", "{{fileName}}"]},
+			{"name": "b", "command": "echo", "args": "-n x"}
+		]}`))
+		So(err, ShouldBeNil)
+		So(c.PluginConfs[0].ArgList, ShouldResemble, []string{"-n", "a  b", "{{fileName}}"})
+		So(c.PluginConfs[0].InjectedArgs(&File{Name: "a.js"}), ShouldResemble, []string{"-n", "a  b", "a.js"})
+		So(c.PluginConfs[1].Args, ShouldEqual, "-n x")
+	})
+
+	Convey("Array args are quoted for a shell", t, func() {
+		p, err := NewCommandPlugin(&PluginConfig{Command: "printf", ArgList: []string{"%s|%s", "it's here", "{{fileContent}}"}, Shell: true})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		res := p.Run(context.Background(), &File{Name: "a.js", Content: "a  b"})
+		So(res.Content, ShouldEqual, "it's here|a  b")
+	})
+
+	Convey("Commands can run through a shell", t, func() {
+		p, err := NewCommandPlugin(&PluginConfig{Command: "printf %s", Args: "{{fileContent}} | tr a-z A-Z", Shell: true})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		res := p.Run(context.Background(), &File{Name: "a.js", Content: "it's $HOME"})
+		So(res.Content, ShouldEqual, "IT'S $HOME")
+	})
+
+	Convey("Commands can have args of their own", t, func() {
+		p, err := NewCommandPlugin(&PluginConfig{Command: "echo -n", Args: "{{fileContent}}"})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		res := p.Run(context.Background(), &File{Name: "a.js", Content: "a  b"})
+		So(res.Content, ShouldEqual, "a  b")
+	})
+}
@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CACHE_DIR is where plugin outputs are kept, relative to the project
+// root.
+const CACHE_DIR = ".devcaddy/cache"
+
+// Cache keeps plugin outputs on disk, keyed by the input file, the plugin
+// config and the plugin script, so a restart only runs the plugins on the
+// files that changed.
+type Cache struct {
+	Dir string
+
+	mu     sync.Mutex
+	hits   int
+	misses int
+}
+
+// CacheStats describes what is in a cache dir.
+type CacheStats struct {
+	Entries int
+	Size    int64
+}
+
+// cacheEntry is a cached output.
+type cacheEntry struct {
+	Name       string
+	Content    string
+	Op         FileOp
+	PluginName string
+	Map        string
+	Outputs    []cacheEntry
+}
+
+func newCacheEntry(f *File) cacheEntry {
+	e := cacheEntry{Name: f.Name, Content: f.Content, Op: f.Op, PluginName: f.PluginName, Map: f.Map}
+	for _, o := range f.Outputs {
+		e.Outputs = append(e.Outputs, newCacheEntry(o))
+	}
+	return e
+}
+
+// file is the entry as an output for in.
+func (e cacheEntry) file(in *File) *File {
+	f := &File{Name: e.Name, Content: e.Content, Op: in.Op, PluginName: e.PluginName, Map: e.Map}
+	if e.Op == LOG {
+		f.Op = LOG
+	}
+	for _, o := range e.Outputs {
+		f.Outputs = append(f.Outputs, o.file(in))
+	}
+	return f
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{Dir: dir}
+}
+
+// cacheable is true if the output of cfg for f can be reused. Log only
+// plugins are run every time, as they are run for what they print or do.
+// Transformers are cheap to run, and their code isn't part of the key.
+// Files whose output depends on other files, ie. the proxy file of a
+// watcher, are not cached either.
+func (cfg *PluginConfig) cacheable(f *File) bool {
+	return !cfg.NoCache && !cfg.LogOnly && !cfg.NoOutput && cfg.Transformer == "" && !f.NoCache && !f.IsDeleted() && !f.IsError()
+}
+
+// Key hashes f's name and content along with cfg, its args and its
+// script.
+func (c *Cache) Key(cfg *PluginConfig, f *File) string {
+	args, _ := cfg.argWords()
+
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	enc.Encode(cfg)
+	enc.Encode(map[string]interface{}{
+		"args":    args,
+		"script":  scriptHash(cfg),
+		"name":    f.Name,
+		"content": f.Content,
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// scriptHash hashes the plugin's script, so editing it invalidates its
+// outputs.
+func scriptHash(cfg *PluginConfig) string {
+	h := sha256.New()
+	if path := cfg.script(); path != "" {
+		if b, err := ioutil.ReadFile(path); err == nil {
+			h.Write([]byte(path))
+			h.Write(b)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// script is the plugin's path, or the first file named by the command or
+// the args before any variable, ie. "plugin.go" for "go run plugin.go
+// {{fileName}}". Files after a variable, such as output targets, are not
+// scripts.
+func (cfg *PluginConfig) script() string {
+	if cfg.Path != "" {
+		return cfg.Path
+	}
+
+	words, _ := splitArgs(cfg.Command)
+	args, _ := cfg.argWords()
+	for _, word := range append(words, args...) {
+		if strings.Contains(word, "{{") {
+			break
+		}
+		if strings.HasPrefix(word, "-") {
+			continue
+		}
+		if info, err := os.Stat(word); err == nil && !info.IsDir() {
+			return word
+		}
+	}
+	return ""
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key[:2], key+".json")
+}
+
+// Get returns the output cached for key, with the op of in.
+func (c *Cache) Get(key string, in *File) (*File, bool) {
+	b, err := ioutil.ReadFile(c.path(key))
+	e := cacheEntry{}
+	if err == nil {
+		err = json.Unmarshal(b, &e)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return e.file(in), true
+}
+
+// Put caches f under key. Errors are not cached, as they may not happen
+// again.
+func (c *Cache) Put(key string, f *File) error {
+	if f == nil || f.IsError() {
+		return nil
+	}
+
+	b, err := json.Marshal(newCacheEntry(f))
+	if err != nil {
+		return err
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// written aside then renamed, so a partial entry is never read
+	tmp, err := ioutil.TempFile(filepath.Dir(path), key)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Counts returns the number of outputs found and not found in the cache
+// since it was created.
+func (c *Cache) Counts() (hits, misses int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Stats counts the entries in the cache dir and their size.
+func (c *Cache) Stats() (CacheStats, error) {
+	s := CacheStats{}
+	err := filepath.Walk(c.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".json" {
+			s.Entries++
+			s.Size += info.Size()
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return s, err
+}
+
+// Clear removes every cached output.
+func (c *Cache) Clear() error {
+	return os.RemoveAll(c.Dir)
+}
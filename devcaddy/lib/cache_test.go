@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCache(t *testing.T) {
+	Convey("Given a cache", t, func() {
+		dir, err := ioutil.TempDir("", "devcaddy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		script := filepath.Join(dir, "plugin.js")
+		ioutil.WriteFile(script, []byte("1"), 0600)
+
+		c := NewCache(filepath.Join(dir, CACHE_DIR))
+		cfg := &PluginConfig{Name: "p", Command: "node", Args: script + " {{fileContent}}", Opts: map[string]interface{}{"a": 1}}
+		f := &File{Name: "a.js", Content: "a", Op: CREATE}
+		key := c.Key(cfg, f)
+
+		Convey("The key changes with the input, the config, its opts and the script", func() {
+			So(c.Key(cfg, f), ShouldEqual, key)
+			So(c.Key(cfg, &File{Name: "a.js", Content: "b", Op: CREATE}), ShouldNotEqual, key)
+			So(c.Key(cfg, &File{Name: "a.js", Content: "a", Op: WRITE}), ShouldEqual, key)
+
+			other := *cfg
+			other.Opts = map[string]interface{}{"a": 2}
+			So(c.Key(&other, f), ShouldNotEqual, key)
+
+			ioutil.WriteFile(script, []byte("2"), 0600)
+			So(c.Key(cfg, f), ShouldNotEqual, key)
+		})
+
+		Convey("Only the script is hashed, not the files named after a variable", func() {
+			target := filepath.Join(dir, "app.css")
+			ioutil.WriteFile(target, []byte("a"), 0600)
+			out := &PluginConfig{Name: "p", Command: "node", Args: script + " {{fileName}} " + target}
+			So(out.script(), ShouldEqual, script)
+
+			before := c.Key(out, f)
+			ioutil.WriteFile(target, []byte("b"), 0600)
+			So(c.Key(out, f), ShouldEqual, before)
+
+			So((&PluginConfig{Path: "plugin.rb", Args: script}).script(), ShouldEqual, "plugin.rb")
+			So((&PluginConfig{Command: "sass", Args: "{{fileName}} " + target}).script(), ShouldEqual, "")
+		})
+
+		Convey("Outputs are kept with the op of the new input", func() {
+			_, ok := c.Get(key, f)
+			So(ok, ShouldBeFalse)
+
+			So(c.Put(key, &File{Name: "a.js", Content: "A", Op: CREATE}), ShouldBeNil)
+			out, ok := c.Get(key, &File{Name: "a.js", Op: WRITE})
+			So(ok, ShouldBeTrue)
+			So(out.Content, ShouldEqual, "A")
+			So(out.Op, ShouldEqual, WRITE)
+
+			hits, misses := c.Counts()
+			So(hits, ShouldEqual, 1)
+			So(misses, ShouldEqual, 1)
+		})
+
+		Convey("Errors are not cached", func() {
+			c.Put(key, &File{Name: "a.js", Op: ERROR})
+			_, ok := c.Get(key, f)
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("Stats count the entries until cleared", func() {
+			c.Put(key, f)
+			c.Put(c.Key(cfg, &File{Name: "b.js"}), f)
+
+			s, err := c.Stats()
+			So(err, ShouldBeNil)
+			So(s.Entries, ShouldEqual, 2)
+			So(s.Size, ShouldBeGreaterThan, 0)
+
+			So(c.Clear(), ShouldBeNil)
+			s, _ = c.Stats()
+			So(s.Entries, ShouldEqual, 0)
+		})
+
+		Convey("A plugin with a cache only runs on new inputs", func() {
+			runs := 0
+			p := NewPlugin(&PluginConfig{Name: "count"}, func(f *File) *File {
+				runs++
+				return &File{Name: f.Name, Content: f.Content + "!", Op: f.Op}
+			})
+			p.cache = c
+			defer p.Close()
+
+			So(p.Run(context.Background(), f).Content, ShouldEqual, "a!")
+			So(p.Run(context.Background(), &File{Name: "a.js", Content: "a", Op: WRITE}).Content, ShouldEqual, "a!")
+			So(runs, ShouldEqual, 1)
+
+			p.Run(context.Background(), &File{Name: "a.js", Content: "b", Op: WRITE})
+			So(runs, ShouldEqual, 2)
+		})
+
+		Convey("Files that depend on other files are not cached", func() {
+			runs := 0
+			p := NewPlugin(&PluginConfig{Name: "sass"}, func(f *File) *File {
+				runs++
+				return &File{Name: f.Name, Content: f.Content, Op: f.Op}
+			})
+			p.cache = c
+			defer p.Close()
+
+			proxy := &File{Name: "app.scss", Content: "@import 'a';", Op: WRITE, NoCache: true}
+			p.Run(context.Background(), proxy)
+			p.Run(context.Background(), proxy)
+			So(runs, ShouldEqual, 2)
+		})
+
+		Convey("Log only plugins are not cached", func() {
+			runs := 0
+			p := NewPlugin(&PluginConfig{Name: "lint", LogOnly: true}, func(f *File) *File {
+				runs++
+				return f
+			})
+			p.cache = c
+			defer p.Close()
+
+			p.Run(context.Background(), f)
+			p.Run(context.Background(), f)
+			So(runs, ShouldEqual, 2)
+		})
+	})
+}
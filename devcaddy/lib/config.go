@@ -1,10 +1,13 @@
 package lib
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -12,25 +15,56 @@ type Config struct {
 	PluginConfs  []*PluginConfig  `json:"plugins"`
 	WatcherConfs []*WatcherConfig `json:"watch"`
 	Files        []*File          `json:"files"`
+	Server       ServerConfig     `json:"server"`
 	Plugins      *Plugins         // TODO remove this
+
+	// Concurrency is how many plugin runs happen at once across all
+	// plugins, one per CPU by default.
+	Concurrency int `json:"concurrency"`
+
+	// NoCache runs every plugin on every file at startup rather than
+	// reusing the outputs kept in CACHE_DIR.
+	NoCache bool `json:"noCache"`
+
+	// Log receives the messages of everything started from this config.
+	// Nothing is logged when it is nil.
+	Log *Logger `json:"-"`
+
+	// Profile is the profile that was applied. The config's "profile"
+	// is used when none is requested.
+	Profile  string                 `json:"profile"`
+	Profiles map[string]interface{} `json:"profiles"`
 }
 
-func NewConfig(cfg []byte) *Config {
+// NewConfig parses and validates cfg. All of the problems found are
+// returned together as ConfigErrors.
+func NewConfig(cfg []byte) (*Config, error) {
+	return NewConfigForProfile(cfg, "")
+}
+
+// NewConfigForProfile is NewConfig with the named profile merged in.
+func NewConfigForProfile(cfg []byte, profile string) (*Config, error) {
 	config := Config{}
 
 	if len(cfg) == 0 {
-		return &config
+		return &config, nil
 	}
 
-	err := json.Unmarshal(cfg, &config)
+	cfg, profile, err := withProfile(cfg, profile)
 	if err != nil {
-		log.Fatalln(err, ERROR_CONFIG_PARSE)
+		return nil, err
 	}
 
+	err = json.Unmarshal(cfg, &config)
+	if err != nil {
+		return nil, jsonError(cfg, err)
+	}
+	config.Profile = profile
+
 	if config.Root == "" {
 		cwd, err := os.Getwd()
 		if err != nil {
-			log.Fatalln(ERROR_CONFIG_ROOT)
+			return nil, ConfigErrors{{Path: "root", Message: err.Error(), Help: ERROR_CONFIG_ROOT}}
 		}
 
 		config.Root = "../" + filepath.Base(cwd)
@@ -40,9 +74,142 @@ func NewConfig(cfg []byte) *Config {
 		f.Type = "merge"
 	}
 
-	return &config
+	errs := ConfigErrors{}
+	for i, pc := range config.PluginConfs {
+		errs.Append("plugins["+strconv.Itoa(i)+"]", pc.decodeArgs())
+	}
+	errs = append(errs, config.expandVars(os.LookupEnv)...)
+	errs = append(errs, config.Validate()...)
+	return &config, errs.Err()
+}
+
+// Validate checks the plugin and watcher definitions. It does not start
+// any plugins.
+func (c *Config) Validate() ConfigErrors {
+	errs := ConfigErrors{}
+	plugins := map[string]bool{"_identity_": true}
+	logOnly := map[string]bool{"_identity_": false}
+
+	for i, pc := range c.PluginConfs {
+		path := "plugins[" + strconv.Itoa(i) + "]"
+
+		if err := pc.Parse(); err != nil {
+			errs.Append(path, err)
+			continue
+		}
+
+		if plugins[pc.Name] {
+			errs.Add(path+".name", fmt.Sprintf("plugin %q is defined more than once", pc.Name), ERROR_PLUGIN_DUPLICATE)
+		}
+		plugins[pc.Name] = true
+		pc.root = c.Root
+		logOnly[pc.Name] = pc.LogOnly
+
+		if pc.IsProcess() {
+			if _, err := os.Stat(pc.Path); err != nil {
+				errs.Add(path+".path", err.Error(), ERROR_PLUGIN_PATH)
+			}
+		}
+	}
+
+	for i, pc := range c.PluginConfs {
+		if pc.PipeTo != "" {
+			path := "plugins[" + strconv.Itoa(i) + "].pipeTo"
+			msg := fmt.Sprintf("pipeTo is no longer supported, list %q after %q in the plugins of the entries using it", pc.PipeTo, pc.Name)
+			errs.Add(path, msg, ERROR_PLUGIN_PIPELINE)
+		}
+	}
+
+	watchers := map[string]string{}
+	checkWatcher := func(path string, wc *WatcherConfig) {
+		name := wc.WatcherName()
+		if prev, ok := watchers[name]; ok {
+			errs.Add(path, fmt.Sprintf("watcher %q is already defined at %s", name, prev), ERROR_WATCHER_DUPLICATE)
+		} else {
+			watchers[name] = path
+		}
+		errs.Append(path, wc.validateGraph(logOnly))
+		errs.Append(path, wc.Filter.check())
+	}
+
+	for i, f := range c.Files {
+		path := "files[" + strconv.Itoa(i) + "]"
+		if f.Name == "" {
+			errs.Add(path+".name", "a file needs a name", "")
+			continue
+		}
+		checkWatcher(path, f.watcherConfig())
+	}
+
+	for i, wc := range c.WatcherConfs {
+		checkWatcher("watch["+strconv.Itoa(i)+"]", wc)
+	}
+
+	return errs
 }
 
 func (c *Config) GetPlugin(name string) *Plugin {
 	return c.Plugins.Get(name)
 }
+
+// withProfile returns cfg with the profile applied, along with the name of
+// the profile.
+func withProfile(cfg []byte, profile string) ([]byte, string, error) {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(cfg, &raw); err != nil {
+		return nil, "", jsonError(cfg, err)
+	}
+
+	if profile == "" {
+		profile, _ = raw["profile"].(string)
+	}
+	if profile == "" {
+		return cfg, "", nil
+	}
+
+	if err := applyProfile(raw, profile); err != nil {
+		return nil, "", err
+	}
+
+	b, err := json.Marshal(raw)
+	return b, profile, err
+}
+
+// jsonError converts a json decoding error into ConfigErrors, pointing at
+// the line and column of syntax errors.
+func jsonError(cfg []byte, err error) ConfigErrors {
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		line, col := position(cfg, e.Offset)
+		msg := fmt.Sprintf("%s (line %d, column %d)", e.Error(), line, col)
+		return ConfigErrors{{Message: msg, Help: ERROR_CONFIG_PARSE}}
+	case *json.UnmarshalTypeError:
+		msg := fmt.Sprintf("expected %s but got %s", e.Type, e.Value)
+		return ConfigErrors{{Path: jsonPath(e.Field), Message: msg, Help: ERROR_CONFIG_PARSE}}
+	}
+	return ConfigErrors{{Message: err.Error(), Help: ERROR_CONFIG_PARSE}}
+}
+
+// jsonPath turns the dotted field of a json error, ie. "plugins.0.name",
+// into a config path, ie. "plugins[0].name".
+func jsonPath(field string) string {
+	path := ""
+	for _, part := range strings.Split(field, ".") {
+		if _, err := strconv.Atoi(part); err == nil {
+			path += "[" + part + "]"
+		} else {
+			path = joinPath(path, part)
+		}
+	}
+	return path
+}
+
+func position(cfg []byte, offset int64) (int, int) {
+	if offset > int64(len(cfg)) {
+		offset = int64(len(cfg))
+	}
+	before := cfg[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndex(before, []byte("\n"))
+	return line, col
+}
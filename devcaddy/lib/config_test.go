@@ -8,13 +8,231 @@ import (
 
 func TestNewConfig(t *testing.T) {
 	Convey("If no root specified in config.json, it sets it to the relative cwd", t, func() {
-		c := NewConfig([]byte("{}"))
+		c, _ := NewConfig([]byte("{}"))
 		So(c.Root, ShouldEqual, "../lib")
 	})
 
 	Convey("It sets all the File object's Type to merge", t, func() {
-		c := NewConfig([]byte(`{ "files": [{ "name": "foo" }] }`))
+		c, _ := NewConfig([]byte(`{ "files": [{ "name": "foo" }] }`))
 
 		So(c.Files[0].Type, ShouldEqual, "merge")
 	})
+
+	Convey("JSON syntax errors report their position", t, func() {
+		_, err := NewConfig([]byte("{\n  \"root\": \"foo\",\n  nope\n}"))
+		errs := err.(ConfigErrors)
+
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Message, ShouldContainSubstring, "line 3")
+		So(errs[0].Help, ShouldEqual, ERROR_CONFIG_PARSE)
+	})
+
+	Convey("Values of the wrong type report their JSON path", t, func() {
+		_, err := NewConfig([]byte(`{"plugins": [{"name": 3}]}`))
+		errs := err.(ConfigErrors)
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Path, ShouldEqual, "plugins[0].name")
+		So(errs[0].Help, ShouldEqual, ERROR_CONFIG_PARSE)
+
+		_, err = NewConfig([]byte(`{"plugins": [{"name": "a", "command": "echo", "args": 3}]}`))
+		errs = err.(ConfigErrors)
+		So(len(errs), ShouldEqual, 1)
+		So(errs[0].Path, ShouldEqual, "plugins[0].args")
+		So(errs[0].Help, ShouldEqual, ERROR_PLUGIN_ARGS)
+	})
+
+	Convey("Every problem is reported with its JSON path", t, func() {
+		_, err := NewConfig([]byte(`{
+			"plugins": [
+				{ "name": "lint", "command": "echo" },
+				{ "name": "lint", "command": "echo" },
+				{ "path": "plugins/foo.unknown" },
+				{ "path": "plugins/missing.js" },
+				{ "name": "tpl", "command": "echo", "pipeTo": "nope" }
+			],
+			"files": [
+				{ "name": "app.js", "plugins": ["lint", "zzz"] },
+				{ "dir": "vendor" }
+			],
+			"watch": [
+				{ "dir": "app", "ext": "hbs", "plugins": ["yyy"] },
+				{ "name": "app.js", "dir": "app" }
+			]
+		}`))
+		errs := err.(ConfigErrors)
+
+		paths := []string{}
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+
+		So(paths, ShouldResemble, []string{
+			"plugins[1].name",
+			"plugins[2].path",
+			"plugins[3].path",
+			"plugins[4].pipeTo",
+			"files[0].plugins[1]",
+			"files[1].name",
+			"watch[0].plugins[0]",
+			"watch[1]",
+		})
+		So(errs[0].Help, ShouldEqual, ERROR_PLUGIN_DUPLICATE)
+		So(errs[4].Message, ShouldContainSubstring, `"zzz" is not defined`)
+		So(errs[7].Message, ShouldContainSubstring, `"app.js" is already defined at files[0]`)
+	})
+
+	Convey("Log only plugins are side plugins rather than pipeline stages", t, func() {
+		_, err := NewConfig([]byte(`{
+			"plugins": [
+				{ "name": "upper", "command": "echo" },
+				{ "name": "lint", "command": "echo", "logOnly": true }
+			],
+			"files": [
+				{ "name": "app.js", "plugins": ["upper", "lint"], "sidePlugins": ["nope"] },
+				{ "name": "lib.js", "plugins": ["upper"], "sidePlugins": ["lint"] }
+			]
+		}`))
+		errs := err.(ConfigErrors)
+
+		So(len(errs), ShouldEqual, 2)
+		So(errs[0].Path, ShouldEqual, "files[0].plugins[1]")
+		So(errs[0].Help, ShouldEqual, ERROR_PLUGIN_PIPELINE)
+		So(errs[1].Path, ShouldEqual, "files[0].sidePlugins[0]")
+	})
+
+	Convey("Graphs are checked for unknown nodes and cycles", t, func() {
+		_, err := NewConfig([]byte(`{
+			"plugins": [
+				{ "name": "a", "command": "echo" },
+				{ "name": "b", "command": "echo" },
+				{ "name": "lint", "command": "echo", "logOnly": true }
+			],
+			"files": [
+				{ "name": "app.js", "graph": [
+					{ "plugin": "a", "from": ["b"] },
+					{ "plugin": "b", "from": ["input", "a"] }
+				] },
+				{ "name": "lib.js", "graph": [
+					{ "plugin": "lint" },
+					{ "plugin": "a", "from": ["lint", "nope"] }
+				] },
+				{ "name": "vendor.js", "plugins": ["a"], "graph": [{ "plugin": "b" }] }
+			]
+		}`))
+		errs := err.(ConfigErrors)
+
+		paths := []string{}
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+
+		So(paths, ShouldResemble, []string{
+			"files[0].graph",
+			"files[1].graph[0].plugin",
+			"files[1].graph[1].from[1]",
+			"files[2].graph",
+		})
+		So(errs[0].Message, ShouldContainSubstring, `"a", "b" read from each other in a cycle`)
+		So(errs[2].Help, ShouldEqual, ERROR_PLUGIN_PIPELINE)
+	})
+
+	Convey("pipeTo is reported with the pipeline to use instead", t, func() {
+		_, err := NewConfig([]byte(`{
+			"plugins": [
+				{ "name": "a", "command": "echo", "pipeTo": "b" },
+				{ "name": "b", "command": "echo" }
+			]
+		}`))
+		errs := err.(ConfigErrors)
+
+		So(errs[0].Path, ShouldEqual, "plugins[0].pipeTo")
+		So(errs[0].Message, ShouldContainSubstring, `list "b" after "a"`)
+	})
+
+	Convey("A valid config has no errors", t, func() {
+		_, err := NewConfig([]byte(`{
+			"plugins": [{ "name": "lint", "command": "echo" }],
+			"files": [{ "name": "app.js", "plugins": ["lint"] }]
+		}`))
+		So(err, ShouldBeNil)
+	})
+}
+
+const profileCfg = `{
+	"profile": "development",
+	"server": { "port": "4200" },
+	"plugins": [
+		{ "command": "echo", "args": "dev", "opts": { "env": "development", "minify": false } },
+		{ "name": "lint", "command": "echo" }
+	],
+	"files": [
+		{ "name": "vendor.js", "dir": "vendor", "files": ["ember/ember.js"] },
+		{ "name": "app.js", "dir": "app", "ext": "js", "plugins": ["lint"] }
+	],
+	"watch": [{ "dir": "app/templates", "ext": "hbs" }],
+	"profiles": {
+		"development": {},
+		"production": {
+			"server": { "port": "8080", "proxy": "http://localhost:3000" },
+			"plugins": [
+				{ "name": "echo", "opts": { "env": "production" } },
+				{ "name": "minify", "command": "echo" }
+			],
+			"files": [
+				{ "name": "vendor.js", "files": ["ember/ember.prod.js"] },
+				{ "name": "app.js", "+plugins": ["minify"] }
+			],
+			"watch": [{ "dir": "app/templates", "ext": "hbs", "plugins": ["minify"] }]
+		}
+	}
+}`
+
+func TestConfigProfiles(t *testing.T) {
+	Convey("Given a config with profiles", t, func() {
+		Convey("The config's own profile is used by default", func() {
+			c, err := NewConfig([]byte(profileCfg))
+			So(err, ShouldBeNil)
+			So(c.Profile, ShouldEqual, "development")
+			So(c.Server.Port, ShouldEqual, "4200")
+			So(c.PluginConfs[0].Opts.(map[string]interface{})["env"], ShouldEqual, "development")
+		})
+
+		Convey("A profile overrides and extends the config", func() {
+			c, err := NewConfigForProfile([]byte(profileCfg), "production")
+			So(err, ShouldBeNil)
+			So(c.Profile, ShouldEqual, "production")
+
+			So(c.Server.Port, ShouldEqual, "8080")
+			So(c.Server.Proxy, ShouldEqual, "http://localhost:3000")
+
+			So(len(c.PluginConfs), ShouldEqual, 3)
+			opts := c.PluginConfs[0].Opts.(map[string]interface{})
+			So(opts["env"], ShouldEqual, "production")
+			So(opts["minify"], ShouldEqual, false)
+			So(c.PluginConfs[0].Args, ShouldEqual, "dev")
+			So(c.PluginConfs[2].Name, ShouldEqual, "minify")
+
+			So(c.Files[0].Files, ShouldResemble, []string{"ember/ember.prod.js"})
+			So(c.Files[0].Dir, ShouldEqual, "vendor")
+			So(c.Files[1].PluginNames, ShouldResemble, []string{"lint", "minify"})
+			So(c.WatcherConfs[0].PluginNames, ShouldResemble, []string{"minify"})
+		})
+
+		Convey("Unknown profiles are errors", func() {
+			_, err := NewConfigForProfile([]byte(profileCfg), "staging")
+			errs := err.(ConfigErrors)
+
+			So(errs[0].Path, ShouldEqual, "profiles")
+			So(errs[0].Help, ShouldContainSubstring, "development, production")
+		})
+
+		Convey("Profile entries without a name are errors", func() {
+			_, err := NewConfigForProfile([]byte(`{
+				"profiles": { "test": { "files": [{ "dir": "app" }] } }
+			}`), "test")
+			errs := err.(ConfigErrors)
+
+			So(errs[0].Path, ShouldEqual, "profiles.test.files[0]")
+		})
+	})
 }
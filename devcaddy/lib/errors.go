@@ -1,5 +1,9 @@
 package lib
 
+import (
+	"strings"
+)
+
 const (
 	ERROR_CONFIG_FILE = `
 Config file was not found.
@@ -27,6 +31,9 @@ Could not determine the command for a plugin.
   - .go (go)
   - .js (node)
   - .rb (ruby)
+  - .py (python3)
+* .js, .rb and .py plugins run in a long lived process and
+  define a plugin(file, settings) function.
 `
 	ERROR_PLUGIN_DUPLICATE = `
 Duplicate plugins detected.
@@ -35,6 +42,115 @@ Duplicate plugins detected.
 * You cannot have multiple plugins with the same name.
   If you have plugins with the same file path in "args",
   you must provide a "name" to the plugins.
+`
+	ERROR_PLUGIN_PATH = `
+Could not read the plugin file.
+* Plugin "path" is relative to where you run the devcaddy
+  command.
+`
+	ERROR_PLUGIN_START = `
+Could not start the plugin process.
+* The plugin is loaded when devcaddy starts, the error
+  above is what its process printed. Check that the plugin
+  file runs and defines a plugin(file, settings) function:
+  exported by node plugins, a method of ruby plugins or a
+  function of python plugins.
+* A plugin that crashes later is restarted, unless it crashes
+  too often.
+`
+	ERROR_PLUGIN_WORKERS = `
+Invalid plugin workers.
+* "workers" is how many processes a process plugin, ie. a
+  .js, .rb or .py "path", starts to handle files at once.
+  It is a positive number, 1 by default.
+* Command plugins run at once up to their "concurrency".
+`
+	ERROR_PLUGIN_TIMEOUT = `
+Invalid plugin timeout.
+* A "timeout" is a number followed by a unit, ie. "500ms",
+  "10s" or "1m".
+`
+	ERROR_GLOB = `
+Invalid glob pattern.
+* "include" and "exclude" list patterns such as "*.min.js",
+  matching the base name, or "app/vendor-shims/**", matching
+  the path from the project root. "**" matches any number of
+  dirs, "*" and "?" don't match "/".
+`
+	ERROR_PLUGIN_ARGS = `
+Invalid plugin command or args.
+* "args" are split like a shell would: quote args with
+  spaces, ie. "--out 'my dir/app.js'", or escape them with
+  a backslash. They can also be an array, one arg per item.
+* Variables such as {{fileContent}} are always a single arg,
+  don't quote them.
+* "shell": true runs the command and args with "sh -c", to
+  use pipes and redirects.
+`
+	ERROR_PLUGIN_IO = `
+Invalid plugin input or output.
+* "input" is how a command gets the file: "argv" passes it
+  as {{fileContent}}, "stdin" pipes it in and "tempfile"
+  writes it to a file whose path is {{inputFile}}.
+* "output" is "stdout" or "file", to read the result from
+  the file at {{outputFile}} once the command is done.
+* Process plugins and transformers have no input or output
+  modes.
+`
+	ERROR_PLUGIN_TRANSFORMER = `
+Invalid plugin transformer.
+* A "transformer" runs in devcaddy rather than as a command.
+  The built in ones are identity, replace, wrap, json-minify
+  and amd-wrap. Others are registered in Go with
+  lib.RegisterTransformer.
+* Their settings go in "opts", ie. {"from": "a", "to": "b"}
+  for replace, {"header": "...", "footer": "..."} for wrap or
+  {"prefix": "app", "base": "app"} for amd-wrap.
+`
+	ERROR_PLUGIN_PIPELINE = `
+Invalid plugin pipeline.
+* The "plugins" of a file or watch entry run in order, each
+  on the output of the one before. A "logOnly" plugin such as
+  a linter would replace that output, list it in
+  "sidePlugins" instead.
+* A "graph" lists nodes that run a "plugin" on the outputs of
+  the nodes named in "from", or on the watcher's "input" by
+  default. The nodes can't read from each other in a cycle.
+* "pipeTo" was replaced by these. List the plugin it piped to
+  after it in "plugins" instead.
+`
+	ERROR_WATCHER_DUPLICATE = `
+Duplicate watchers detected.
+* Each "files" entry is named by its "name". A "watch" entry
+  is named by its "name" or, if not given, by "dir:ext".
+* Give the watchers different names.
+`
+	ERROR_WATCHER_DIR = `
+Could not watch a directory.
+* "dir" is relative to the project "root" and should exist,
+  along with the directories of the "files" listed.
+* The system may limit how many directories can be watched,
+  ie. fs.inotify.max_user_watches on Linux.
+`
+	ERROR_PROFILE_NOT_DEFINED = `
+Profile not defined.
+* The profile you selected, or the "profile" in your config,
+  should be a key of "profiles".
+`
+	ERROR_PROFILE_ENTRY_NAME = `
+Profile entry could not be matched.
+* "plugins", "files" and "watch" entries in a profile are
+  matched with the main config by "name". A "watch" entry
+  may use its "dir" and "ext" instead.
+`
+	ERROR_VARIABLE = `
+Unknown variable.
+* Plugin args can use {{fileName}}, {{fileContent}}, {{dir}},
+  {{ext}}, {{baseName}}, {{outputName}}, {{watcher}},
+  {{inputFile}}, {{outputFile}} and {{opts.key}} for a value
+  of the plugin's "opts".
+* Args, file names and paths can use {{root}}, {{profile}} and
+  ${env:VAR} for environment variables, which must be set.
 `
 	ERROR_PLUGIN_NOT_DEFINED = `
 Plugin not defined.
@@ -43,3 +159,68 @@ Plugin not defined.
   same name as in the file definition.
 `
 )
+
+// ConfigError is a problem found while loading the config. Path is the
+// JSON path of the offending value, ie. "files[2].plugins[0]".
+type ConfigError struct {
+	Path    string
+	Message string
+	Help    string
+}
+
+func (e *ConfigError) Error() string {
+	msg := e.Message
+	if e.Path != "" {
+		msg = e.Path + ": " + msg
+	}
+	return msg
+}
+
+// ConfigErrors collects every problem found in a config so they can be
+// reported at once.
+type ConfigErrors []*ConfigError
+
+func (es ConfigErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (es *ConfigErrors) Add(path, msg, help string) {
+	*es = append(*es, &ConfigError{Path: path, Message: msg, Help: help})
+}
+
+// Append adds err under path. ConfigErrors are merged, prefixing their
+// paths with path.
+func (es *ConfigErrors) Append(path string, err error) {
+	switch e := err.(type) {
+	case nil:
+	case *ConfigError:
+		*es = append(*es, &ConfigError{Path: joinPath(path, e.Path), Message: e.Message, Help: e.Help})
+	case ConfigErrors:
+		for _, ce := range e {
+			es.Append(path, ce)
+		}
+	default:
+		es.Add(path, err.Error(), "")
+	}
+}
+
+func (es ConfigErrors) Err() error {
+	if len(es) == 0 {
+		return nil
+	}
+	return es
+}
+
+func joinPath(parent, child string) string {
+	if parent == "" {
+		return child
+	}
+	if child == "" || child[0] == '[' {
+		return parent + child
+	}
+	return parent + "." + child
+}
@@ -9,10 +9,13 @@ import (
 	"gopkg.in/fsnotify.v0"
 )
 
-var (
-	zeroTime = time.Time{}
-	_events  = Events{contents: make(map[string]time.Time)}
-)
+var zeroTime = time.Time{}
+
+// Events remembers when the events of a watcher last happened, to ignore
+// the duplicates some editors cause.
+func NewEvents() *Events {
+	return &Events{contents: make(map[string]time.Time)}
+}
 
 type Events struct {
 	sync.Mutex
@@ -26,11 +29,13 @@ func (es *Events) Set(e *Event) {
 }
 
 func (es *Events) Get(evt *Event) time.Time {
+	es.Lock()
+	defer es.Unlock()
 	return es.contents[evt.Id]
 }
 
 func (es *Events) ShouldIgnore(e *Event) bool {
-	prevTime := _events.Get(e)
+	prevTime := es.Get(e)
 	return prevTime != zeroTime && e.CreatedAt.Sub(prevTime).Seconds() < 0.01
 }
 
@@ -44,6 +49,13 @@ func NewEvent(e fsnotify.Event, w Watcher) *Event {
 	}
 }
 
+// NewScanEvent is the event for a file found by a watcher's scan.
+func NewScanEvent(name string) *Event {
+	e := NewPseudoEvent(name, CREATE)
+	e.Scan = true
+	return e
+}
+
 func NewPseudoEvent(name string, op FileOp, args ...error) *Event {
 	var err error
 	if args != nil {
@@ -64,6 +76,7 @@ type Event struct {
 	name      string
 	Op        FileOp
 	Error     error
+	Scan      bool
 	CreatedAt time.Time
 }
 
@@ -74,13 +87,13 @@ func (e *Event) Name() string {
 	return e.name
 }
 
-func (e *Event) Ignore() bool {
+func (e *Event) Ignore(es *Events) bool {
 	if e.Op == CHMOD {
 		return true
 	}
 
-	ignore := _events.ShouldIgnore(e)
-	_events.Set(e)
+	ignore := es.ShouldIgnore(e)
+	es.Set(e)
 	return ignore
 }
 
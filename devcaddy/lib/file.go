@@ -20,7 +20,7 @@ const (
 type FileOp uint32
 
 func NewFile(e *Event) *File {
-	f := File{Name: e.Name(), Op: e.Op, Error: e.Error}
+	f := File{Name: e.Name(), Op: e.Op, Error: e.Error, Scan: e.Scan}
 	if f.IsDeleted() || f.IsError() {
 		return &f
 	}
@@ -38,6 +38,16 @@ func NewFileWithContent(name, content string, op FileOp) *File {
 	return &File{Name: name, Content: content, Op: op}
 }
 
+// NewFileFromCommand reads the output of a plugin command. Each output
+// file is its content followed by a FILE_PATH_SPLITTER line naming it, ie.
+//
+//	var a;
+//	__SERVER_FILE_PATH__=app/a.js
+//	{"version":3}
+//	__SERVER_FILE_PATH__=app/a.js.map
+//
+// The first file is returned with the others as its Outputs. Content
+// after the last name keeps the name of oFile.
 func NewFileFromCommand(oFile *File, output []byte, err error, pluginName string) *File {
 	f := &File{
 		Name:       oFile.Name,
@@ -51,19 +61,35 @@ func NewFileFromCommand(oFile *File, output []byte, err error, pluginName string
 	}
 
 	split := strings.Split(string(output), FILE_PATH_SPLITTER)
-
-	if len(split) > 1 {
-		f.Name = strings.TrimSpace(split[1])
+	f.Content = split[0]
+	if len(split) == 1 || f.Op == ERROR {
+		return f
 	}
 
-	f.Content = split[0]
+	cur := f
+	for i, part := range split[1:] {
+		name, rest := part, ""
+		if n := strings.Index(part, "\n"); n >= 0 {
+			name, rest = part[:n], part[n+1:]
+		}
+		cur.Name = strings.TrimSpace(name)
+
+		if i == len(split)-2 && strings.TrimSpace(rest) == "" {
+			break
+		}
+		cur = &File{Name: oFile.Name, Content: rest, Op: f.Op, PluginName: pluginName}
+		f.Outputs = append(f.Outputs, cur)
+	}
 	return f
 }
 
 type FileConfig struct {
-	Dir, Ext    string
-	Files       []string
-	PluginNames []string `json:"plugins"`
+	Dir, Ext        string
+	Files           []string
+	PluginNames     []string     `json:"plugins"`
+	SidePluginNames []string     `json:"sidePlugins"`
+	Graph           []*GraphNode `json:"graph"`
+	Filter
 }
 
 type File struct {
@@ -74,6 +100,38 @@ type File struct {
 	Error      error
 	Op         FileOp
 	PluginName string
+	Watcher    string // name of the watcher that found the file
+	OutputName string // name of the "files" entry it belongs to
+	Scan       bool   // sent by a scan rather than a change
+
+	// NoCache is set on files whose plugin outputs depend on more than
+	// their content, ie. a proxy file compiled with its imports.
+	NoCache bool
+
+	// Outputs are more files produced along with this one, ie. a source
+	// map. They are stored and removed together, by Source.
+	Outputs []*File
+	Source  string // name of the file the watcher found
+
+	// Map is the source map of the content, as JSON. Plugins return it
+	// inline, as an output named after the file with a ".map" extension,
+	// or in the "map" field of process plugin outputs.
+	Map string
+
+	// Warnings and Logs are what the plugins that produced the file
+	// reported about it, printed once it is processed.
+	Warnings []string
+	Logs     []string
+}
+
+// addMessages keeps the warnings and logs of in, the file out was
+// produced from.
+func addMessages(in, out *File) {
+	if in == nil || out == nil || in == out {
+		return
+	}
+	out.Warnings = append(append([]string{}, in.Warnings...), out.Warnings...)
+	out.Logs = append(append([]string{}, in.Logs...), out.Logs...)
 }
 
 func (f *File) IsDeleted() bool {
@@ -4,12 +4,15 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 )
 
 func NewStore(c *Config) *Store {
-	store = &Store{
+	store := &Store{
 		Root:      c.Root,
 		Files:     make(map[string]*File),
+		sources:   map[string][]string{},
+		watchers:  map[string]string{},
 		Input:     make(chan *File),
 		DidUpdate: make(chan *File),
 	}
@@ -21,18 +24,38 @@ func NewStore(c *Config) *Store {
 	return store
 }
 
-var store *Store
-
 type Store struct {
 	Root      string
 	Files     map[string]*File
 	Input     chan *File
 	DidUpdate chan *File // TODO - rename to Output
+	mu        sync.Mutex // guards held, Root, Files, sources and watchers
+	held      bool
+	sources   map[string][]string // names stored for each source file
+	watchers  map[string]string   // watcher that found each source file
+}
+
+// Hold stops DidUpdate from being sent until Release is called.
+func (s *Store) Hold() {
+	s.mu.Lock()
+	s.held = true
+	s.mu.Unlock()
+}
+
+// Release sends a single update for name, standing in for every change
+// made since Hold.
+func (s *Store) Release(name string) {
+	s.mu.Lock()
+	s.held = false
+	s.mu.Unlock()
+	s.doUpdate(&File{Name: name})
 }
 
 func (s *Store) Put(name string, content string, args ...bool) {
 	f := &File{Name: name, Content: content}
+	s.mu.Lock()
 	s.Files[f.Name] = f
+	s.mu.Unlock()
 
 	update := true
 	if args != nil {
@@ -45,7 +68,9 @@ func (s *Store) Put(name string, content string, args ...bool) {
 }
 
 func (s *Store) PutFile(f *File) {
+	s.mu.Lock()
 	s.Files[f.Name] = f
+	s.mu.Unlock()
 	s.doUpdate(f)
 }
 
@@ -58,7 +83,9 @@ func (s *Store) Get(name string) string {
 }
 
 func (s *Store) GetFile(name string) *File {
+	s.mu.Lock()
 	f := s.Files[name]
+	s.mu.Unlock()
 	if f == nil {
 		return nil
 	}
@@ -71,12 +98,16 @@ func (s *Store) GetFile(name string) *File {
 }
 
 func (s *Store) Delete(name string) {
+	s.mu.Lock()
 	delete(s.Files, name)
+	s.mu.Unlock()
 	s.doUpdate(&File{Name: name})
 }
 
 func (s *Store) DeleteFile(f *File) {
+	s.mu.Lock()
 	delete(s.Files, f.Name)
+	s.mu.Unlock()
 	s.doUpdate(f)
 }
 
@@ -88,10 +119,12 @@ func (s *Store) GetAll() (files []*File) {
 }
 
 func (s *Store) SortedFileNames() []string {
+	s.mu.Lock()
 	names := []string{}
 	for name, _ := range s.Files {
 		names = append(names, name)
 	}
+	s.mu.Unlock()
 	sort.Strings(names)
 	return names
 }
@@ -103,41 +136,169 @@ func (s *Store) Listen() {
 			if f == nil {
 				continue
 			}
+			s.Apply(f)
+		}
+	}()
+}
+
+// Apply puts or deletes f and its Outputs depending on its Op. The
+// outputs a source file no longer produces are deleted, and all of them
+// are when it is removed.
+func (s *Store) Apply(f *File) {
+	switch f.Op {
+	case CREATE, WRITE:
+		s.putOutputs(f)
+	case REMOVE, RENAME:
+		s.deleteOutputs(f)
+	}
+}
 
-			switch f.Op {
-			case CREATE:
-				s.PutFile(f)
-			case WRITE:
-				s.PutFile(f)
-			case REMOVE:
-				s.DeleteFile(f)
-			case RENAME:
-				s.DeleteFile(f)
+func (s *Store) putOutputs(f *File) {
+	s.mu.Lock()
+	names := []string{}
+	for _, o := range append([]*File{f}, f.Outputs...) {
+		s.Files[o.Name] = o
+		names = append(names, o.Name)
+	}
+
+	if f.Source != "" {
+		for _, name := range s.sources[f.Source] {
+			if !contains(names, name) {
+				delete(s.Files, name)
 			}
 		}
-	}()
+		s.sources[f.Source] = names
+		if f.Watcher != "" {
+			s.watchers[f.Source] = f.Watcher
+		}
+	}
+	s.mu.Unlock()
+	s.doUpdate(f)
+}
+
+func (s *Store) deleteOutputs(f *File) {
+	s.mu.Lock()
+	delete(s.Files, f.Name)
+	if f.Source != "" {
+		for _, name := range s.sources[f.Source] {
+			delete(s.Files, name)
+		}
+		delete(s.sources, f.Source)
+		delete(s.watchers, f.Source)
+	}
+	s.mu.Unlock()
+	s.doUpdate(f)
+}
+
+// RemoveWatcher deletes the files stored from the sources the named
+// watcher found.
+func (s *Store) RemoveWatcher(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for source, w := range s.watchers {
+		if w != name {
+			continue
+		}
+		for _, n := range s.sources[source] {
+			delete(s.Files, n)
+		}
+		delete(s.sources, source)
+		delete(s.watchers, source)
+	}
+}
+
+// Replace swaps the "files" entries of the old config for the ones of the
+// new config, found under root.
+func (s *Store) Replace(old, files []*File, root string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range old {
+		delete(s.Files, f.Name)
+	}
+	for _, f := range files {
+		s.Files[f.Name] = f
+	}
+	s.Root = root
 }
 
 func (s *Store) MergeStoreFiles(file *File) string {
 	contents := []string{}
+	for _, f := range s.mergeParts(file) {
+		contents = append(contents, f.Content)
+	}
+
+	file.Content = strings.Join(contents, "\n")
+	return file.Content
+}
+
+// mergeParts are the files joined into a merge file, in order. Missing
+// files are empty.
+func (s *Store) mergeParts(file *File) []*File {
+	parts := []*File{}
+	s.mu.Lock()
 	dir := filepath.Join(s.Root, file.Dir)
+	s.mu.Unlock()
 
 	if len(file.Files) > 0 {
 		for _, f := range file.Files {
 			path := filepath.Join(dir, f)
-			contents = append(contents, s.Get(path))
+			part := s.GetFile(path)
+			if part == nil {
+				part = &File{Name: path}
+			}
+			parts = append(parts, part)
 		}
 	} else {
 		for _, n := range s.SortedFileNames() {
+			s.mu.Lock()
 			f := s.Files[n]
-			if strings.Contains(f.Name, dir) && strings.HasSuffix(f.Name, "."+file.Ext) {
-				contents = append(contents, f.Content)
+			s.mu.Unlock()
+			if f != nil && strings.Contains(f.Name, dir) && strings.HasSuffix(f.Name, "."+file.Ext) {
+				parts = append(parts, f)
 			}
 		}
 	}
+	return parts
+}
 
-	file.Content = strings.Join(contents, "\n")
-	return file.Content
+// HasSourceMap is true if SourceMap has a map for name: it was given one
+// by its plugins, or it is a merge of scripts or styles.
+func (s *Store) HasSourceMap(name string) bool {
+	s.mu.Lock()
+	f := s.Files[name]
+	s.mu.Unlock()
+	if f == nil {
+		return false
+	}
+	return f.Map != "" || (f.Type == "merge" && SOURCE_MAP_EXTS[filepath.Ext(name)])
+}
+
+// SourceMap is the source map of the named file, with the sources under
+// the root made relative to it. Merge files map each line to the file it
+// came from, or through that file's own map. It is empty if the file has
+// no map.
+func (s *Store) SourceMap(name string) string {
+	if !s.HasSourceMap(name) {
+		return ""
+	}
+
+	s.mu.Lock()
+	f := s.Files[name]
+	root := s.Root
+	s.mu.Unlock()
+	var m *SourceMap
+	if f.Type == "merge" {
+		m = concatMaps(s.mergeParts(f))
+	} else {
+		var err error
+		if m, err = ParseSourceMap(f.Map); err != nil {
+			return ""
+		}
+	}
+
+	m.File = filepath.Base(name)
+	m.Relative(root)
+	return m.String()
 }
 
 func (s *Store) GetAllContents() string {
@@ -149,6 +310,13 @@ func (s *Store) GetAllContents() string {
 }
 
 func (s *Store) doUpdate(f *File) {
+	s.mu.Lock()
+	held := s.held
+	s.mu.Unlock()
+	if held {
+		return
+	}
+
 	go func() {
 		s.DidUpdate <- f
 	}()
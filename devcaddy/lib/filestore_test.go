@@ -35,7 +35,7 @@ const cfg = `
 
 func TestFileAccessing(t *testing.T) {
 	Convey("Given files exist in the store", t, func() {
-		store := NewStore(NewConfig([]byte(cfg)))
+		store := NewStore(newTestConfig(t, cfg))
 		store.Put("/proj/app/controllers/foo.js", "foo")
 		store.Put("/proj/app/models/bar.js", "bar")
 		store.Put("/proj/app/routes/baz.js", "baz")
@@ -101,7 +101,7 @@ func TestFileAccessing(t *testing.T) {
 
 func TestFileAccessors(t *testing.T) {
 	Convey("Given files exist in the store", t, func() {
-		store := NewStore(NewConfig([]byte(cfg)))
+		store := NewStore(newTestConfig(t, cfg))
 		store.Put("/proj/app/controllers/foo.js", "foo")
 		store.Put("/proj/app/models/bar.js", "bar")
 		store.Put("/proj/app/routes/baz.js", "baz")
@@ -125,12 +125,44 @@ func TestFileAccessors(t *testing.T) {
 			So(f.Content, ShouldEqual, "foo\nbar\nbaz")
 		})
 
+		Convey("Merge files map each line to the file it came from", func() {
+			store.Put("/proj/app/routes/baz.js", "var baz")
+			store.Files["/proj/app/routes/baz.js"].Map = `{"version":3,"sources":["/proj/app/routes/baz.es6"],"names":[],"mappings":"AAAA"}`
+
+			So(store.HasSourceMap("vendor.js"), ShouldBeTrue)
+			So(store.HasSourceMap("/proj/app/controllers/foo.js"), ShouldBeFalse)
+			So(store.HasSourceMap("/proj/app/routes/baz.js"), ShouldBeTrue)
+
+			m, err := ParseSourceMap(store.SourceMap("app.js"))
+			So(err, ShouldBeNil)
+			So(m.File, ShouldEqual, "app.js")
+			So(m.Sources, ShouldResemble, []string{"/app/controllers/foo.js", "/app/models/bar.js", "/app/routes/baz.es6"})
+			So(m.Lines[2], ShouldResemble, []Mapping{{Column: 0, Source: 2, Line: 0, Col: 0, Name: -1}})
+			So(*m.Contents[0], ShouldEqual, "foo")
+		})
+
+		Convey("The outputs of a source file are stored and removed together", func() {
+			src := "/proj/app/templates/app.hbs"
+			map1 := &File{Name: "/proj/app/templates/app.js.map", Content: "{}"}
+			partial := &File{Name: "/proj/app/templates/_nav.js", Content: "nav"}
+			store.Apply(&File{Name: "/proj/app/templates/app.js", Content: "tpl", Op: CREATE, Source: src, Outputs: []*File{map1, partial}})
+
+			So(store.Get("/proj/app/templates/app.js.map"), ShouldEqual, "{}")
+			So(store.Get("/proj/app/templates/_nav.js"), ShouldEqual, "nav")
+
+			store.Apply(&File{Name: "/proj/app/templates/app.js", Content: "tpl2", Op: WRITE, Source: src, Outputs: []*File{map1}})
+			So(store.GetFile("/proj/app/templates/_nav.js"), ShouldBeNil)
+
+			store.Apply(&File{Name: src, Op: REMOVE, Source: src})
+			So(store.GetFile("/proj/app/templates/app.js"), ShouldBeNil)
+			So(store.GetFile("/proj/app/templates/app.js.map"), ShouldBeNil)
+		})
 	})
 }
 
 func TestUpdatedChannel(t *testing.T) {
 	Convey("Given a file was added", t, func() {
-		store := NewStore(NewConfig([]byte(cfg)))
+		store := NewStore(newTestConfig(t, cfg))
 		store.Put("/proj/app/controllers/foo.js", "foo")
 		res := <-store.DidUpdate
 
@@ -149,12 +181,12 @@ func TestUpdatedChannel(t *testing.T) {
 
 func TestStoreListen(t *testing.T) {
 	Convey("Given a store is listening for files", t, func() {
-		s := NewStore(NewConfig([]byte(cfg)))
+		s := NewStore(newTestConfig(t, cfg))
 		s.Listen()
 
 		Convey("It handles file create", func() {
 			s.Input <- NewFileWithContent("/proj/app/controllers/foo.js", "foo", CREATE)
-			f := <-store.DidUpdate
+			f := <-s.DidUpdate
 
 			So(f.Name, ShouldEqual, "/proj/app/controllers/foo.js")
 			So(f.Op, ShouldEqual, CREATE)
@@ -162,7 +194,7 @@ func TestStoreListen(t *testing.T) {
 
 		Convey("It handles file write", func() {
 			s.Input <- NewFileWithContent("/proj/app/controllers/1.js", "1", WRITE)
-			f := <-store.DidUpdate
+			f := <-s.DidUpdate
 
 			So(f.Name, ShouldEqual, "/proj/app/controllers/1.js")
 			So(f.Op, ShouldEqual, WRITE)
@@ -170,18 +202,18 @@ func TestStoreListen(t *testing.T) {
 
 		Convey("It handles file removal", func() {
 			s.Input <- NewFileWithContent("/proj/app/controllers/2.js", "", REMOVE)
-			f := <-store.DidUpdate
+			f := <-s.DidUpdate
 
-			So(store.GetFile("/proj/app/controllers/2.js"), ShouldBeNil)
+			So(s.GetFile("/proj/app/controllers/2.js"), ShouldBeNil)
 			So(f.Name, ShouldEqual, "/proj/app/controllers/2.js")
 			So(f.Op, ShouldEqual, REMOVE)
 		})
 
 		Convey("It handles file rename", func() {
 			s.Input <- NewFileWithContent("/proj/app/controllers/3.js", "", RENAME)
-			f := <-store.DidUpdate
+			f := <-s.DidUpdate
 
-			So(store.GetFile("/proj/app/controllers/3.js"), ShouldBeNil)
+			So(s.GetFile("/proj/app/controllers/3.js"), ShouldBeNil)
 			So(f.Name, ShouldEqual, "/proj/app/controllers/3.js")
 			So(f.Op, ShouldEqual, RENAME)
 		})
@@ -190,7 +222,7 @@ func TestStoreListen(t *testing.T) {
 			s.Input <- NewFileWithContent("/proj/app/controllers/4.js", "", CHMOD)
 
 			select {
-			case f := <-store.DidUpdate:
+			case f := <-s.DidUpdate:
 				So("Fail - Store should not update", ShouldEqual, f)
 			default:
 				So("Pass - Store did not update", ShouldNotBeBlank)
@@ -203,7 +235,7 @@ func TestStoreListen(t *testing.T) {
 			time.Sleep(time.Millisecond * 30)
 
 			select {
-			case f := <-store.DidUpdate:
+			case f := <-s.DidUpdate:
 				So("Fail - Store should not update", ShouldEqual, f)
 			default:
 				So("Pass - Store did not update", ShouldNotBeBlank)
@@ -215,7 +247,7 @@ func TestStoreListen(t *testing.T) {
 			time.Sleep(time.Millisecond * 30)
 
 			select {
-			case f := <-store.DidUpdate:
+			case f := <-s.DidUpdate:
 				So("Fail - Store should not update", ShouldEqual, f)
 			default:
 				So("Pass - Store did not update", ShouldNotBeBlank)
@@ -0,0 +1,99 @@
+package lib
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Filter selects files with glob patterns, set by "include" and "exclude"
+// on plugins and watchers. Patterns with a "/" match the path relative to
+// the project root, ie. "app/vendor-shims/**", others the base name, ie.
+// "*.min.js". "**" matches any number of dirs.
+type Filter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Matches is true if name is included, or nothing is, and not excluded.
+func (ft *Filter) Matches(root, name string) bool {
+	rel := filepath.ToSlash(name)
+	if root != "" {
+		if r, err := filepath.Rel(root, name); err == nil && !strings.HasPrefix(r, "..") {
+			rel = filepath.ToSlash(r)
+		}
+	}
+	rel = strings.TrimPrefix(rel, "./")
+
+	for _, pattern := range ft.Exclude {
+		if matchGlob(pattern, rel) {
+			return false
+		}
+	}
+	if len(ft.Include) == 0 {
+		return true
+	}
+	for _, pattern := range ft.Include {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports the invalid patterns.
+func (ft *Filter) check() ConfigErrors {
+	errs := ConfigErrors{}
+	lists := []struct {
+		key      string
+		patterns []string
+	}{{"include", ft.Include}, {"exclude", ft.Exclude}}
+
+	for _, l := range lists {
+		for i, pattern := range l.patterns {
+			for _, seg := range strings.Split(pattern, "/") {
+				if _, err := path.Match(seg, ""); err != nil || pattern == "" {
+					msg := fmt.Sprintf("invalid glob pattern %q", pattern)
+					errs.Add(l.key+"["+strconv.Itoa(i)+"]", msg, ERROR_GLOB)
+					break
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// matchGlob matches a pattern against a slash separated relative path.
+func matchGlob(pattern, name string) bool {
+	pattern = strings.TrimPrefix(pattern, "/")
+	if !strings.Contains(pattern, "/") && pattern != "**" {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// as few dirs as possible, then one more each time
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
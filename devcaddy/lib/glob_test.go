@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFilter(t *testing.T) {
+	Convey("Glob patterns", t, func() {
+		Convey("without a slash match the base name", func() {
+			So(matchGlob("*.min.js", "vendor/jquery.min.js"), ShouldBeTrue)
+			So(matchGlob("*.min.js", "vendor/jquery.js"), ShouldBeFalse)
+		})
+
+		Convey("with a slash match the whole path", func() {
+			So(matchGlob("app/*.js", "app/app.js"), ShouldBeTrue)
+			So(matchGlob("app/*.js", "app/routes/foo.js"), ShouldBeFalse)
+			So(matchGlob("app/**/*.js", "app/app.js"), ShouldBeTrue)
+			So(matchGlob("app/**/*.js", "app/routes/foo/bar.js"), ShouldBeTrue)
+			So(matchGlob("app/vendor-shims/**", "app/vendor-shims/a/b.js"), ShouldBeTrue)
+			So(matchGlob("app/vendor-shims/**", "app/shims/b.js"), ShouldBeFalse)
+			So(matchGlob("**/test/*", "app/test/a.js"), ShouldBeTrue)
+		})
+	})
+
+	Convey("Filters match paths relative to the root", t, func() {
+		ft := Filter{Include: []string{"app/**"}, Exclude: []string{"app/vendor-shims/**", "*.min.js"}}
+
+		So(ft.Matches("/proj", "/proj/app/app.js"), ShouldBeTrue)
+		So(ft.Matches("/proj", "/proj/app/vendor-shims/ember.js"), ShouldBeFalse)
+		So(ft.Matches("/proj", "/proj/app/lib.min.js"), ShouldBeFalse)
+		So(ft.Matches("/proj", "/proj/vendor/a.js"), ShouldBeFalse)
+		So(ft.Matches("../proj", "../proj/app/a.js"), ShouldBeTrue)
+		So((&Filter{}).Matches("/proj", "/proj/vendor/a.js"), ShouldBeTrue)
+	})
+
+	Convey("Invalid patterns are config errors", t, func() {
+		err := (&PluginConfig{Command: "cat", Filter: Filter{Exclude: []string{"ok", "app/[a"}}}).Parse().(ConfigErrors)
+		So(err[0].Path, ShouldEqual, "exclude[1]")
+		So(err[0].Help, ShouldEqual, ERROR_GLOB)
+	})
+
+	Convey("Skipped files pass through a plugin as they are", t, func() {
+		p := NewPlugin(&PluginConfig{Filter: Filter{Include: []string{"*.hbs"}}}, func(f *File) *File {
+			return &File{Name: f.Name, Content: "compiled", Op: f.Op}
+		})
+		defer p.Close()
+
+		in := &File{Name: "app/a.js", Content: "a", Op: WRITE}
+		p.InC <- in
+		So(<-p.OutC, ShouldEqual, in)
+
+		p.InC <- &File{Name: "app/a.hbs", Content: "a", Op: WRITE}
+		So((<-p.OutC).Content, ShouldEqual, "compiled")
+	})
+}
@@ -0,0 +1,271 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GRAPH_INPUT is the node name for the file found by the watcher.
+const GRAPH_INPUT = "input"
+
+// GraphNode runs a plugin on the outputs of the nodes it reads from. The
+// outputs of several nodes are merged into one file, and the outputs of
+// the nodes no other node reads from are the watcher's outputs, ie.
+//
+//	"graph": [
+//	    { "name": "js", "plugin": "es6-transpiler" },
+//	    { "name": "lint", "plugin": "jshint" },
+//	    { "plugin": "minify", "from": ["js"] }
+//	]
+type GraphNode struct {
+	Name   string   `json:"name"` // defaults to the plugin name
+	Plugin string   `json:"plugin"`
+	From   []string `json:"from"` // the watcher's input by default
+
+	side bool   // a sidePlugins entry, whose output is only logged
+	path string // config path, ie. "graph[2]" or "plugins[1]"
+}
+
+// nodes is the watcher's "graph", or else its "plugins" as a chain and
+// its "sidePlugins" reading the input.
+func (c *WatcherConfig) nodes() []*GraphNode {
+	if len(c.Graph) > 0 {
+		nodes := []*GraphNode{}
+		for i, n := range c.Graph {
+			node := *n
+			if node.Name == "" {
+				node.Name = node.Plugin
+			}
+			if len(node.From) == 0 {
+				node.From = []string{GRAPH_INPUT}
+			}
+			node.path = "graph[" + strconv.Itoa(i) + "]"
+			nodes = append(nodes, &node)
+		}
+		return nodes
+	}
+
+	nodes := []*GraphNode{}
+	from := GRAPH_INPUT
+	for i, name := range c.PluginNames {
+		node := &GraphNode{
+			Name:   "plugins[" + strconv.Itoa(i) + "]",
+			Plugin: name,
+			From:   []string{from},
+			path:   "plugins[" + strconv.Itoa(i) + "]",
+		}
+		nodes = append(nodes, node)
+		from = node.Name
+	}
+
+	for i, name := range c.SidePluginNames {
+		nodes = append(nodes, &GraphNode{
+			Name:   "sidePlugins[" + strconv.Itoa(i) + "]",
+			Plugin: name,
+			From:   []string{GRAPH_INPUT},
+			side:   true,
+			path:   "sidePlugins[" + strconv.Itoa(i) + "]",
+		})
+	}
+	return nodes
+}
+
+// PluginNamesUsed lists the plugins of the watcher's pipeline, side
+// plugins and graph.
+func (c *WatcherConfig) PluginNamesUsed() []string {
+	names := []string{}
+	for _, n := range c.nodes() {
+		names = append(names, n.Plugin)
+	}
+	return names
+}
+
+// sortNodes orders nodes so that each comes after the nodes it reads
+// from. Unknown and duplicate names and cycles are reported.
+func sortNodes(nodes []*GraphNode) ([]*GraphNode, ConfigErrors) {
+	errs := ConfigErrors{}
+	byName := map[string]*GraphNode{}
+
+	for _, n := range nodes {
+		if n.Name == GRAPH_INPUT {
+			errs.Add(n.path+".name", fmt.Sprintf("%q is the watcher's input and can't name a node", GRAPH_INPUT), ERROR_PLUGIN_PIPELINE)
+		} else if byName[n.Name] != nil {
+			errs.Add(n.path+".name", fmt.Sprintf("node %q is defined more than once", n.Name), ERROR_PLUGIN_PIPELINE)
+		}
+		byName[n.Name] = n
+	}
+
+	for _, n := range nodes {
+		for j, from := range n.From {
+			if from != GRAPH_INPUT && byName[from] == nil {
+				errs.Add(n.path+".from["+strconv.Itoa(j)+"]", fmt.Sprintf("node %q is not defined", from), ERROR_PLUGIN_PIPELINE)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	sorted := []*GraphNode{}
+	added := map[string]bool{GRAPH_INPUT: true}
+
+	for len(sorted) < len(nodes) {
+		grew := false
+		for _, n := range nodes {
+			if added[n.Name] || !allAdded(added, n.From) {
+				continue
+			}
+			sorted = append(sorted, n)
+			added[n.Name] = true
+			grew = true
+		}
+
+		if !grew {
+			cycle := []string{}
+			for _, n := range nodes {
+				if !added[n.Name] {
+					cycle = append(cycle, strconv.Quote(n.Name))
+				}
+			}
+			msg := fmt.Sprintf("nodes %s read from each other in a cycle", strings.Join(cycle, ", "))
+			errs.Add("graph", msg, ERROR_PLUGIN_PIPELINE)
+			return nil, errs
+		}
+	}
+	return sorted, nil
+}
+
+func allAdded(added map[string]bool, names []string) bool {
+	for _, name := range names {
+		if !added[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// validateGraph checks the watcher's nodes against the defined plugins,
+// whose values tell if they only log.
+func (c *WatcherConfig) validateGraph(plugins map[string]bool) ConfigErrors {
+	errs := ConfigErrors{}
+
+	if len(c.Graph) > 0 && len(c.PluginNames)+len(c.SidePluginNames) > 0 {
+		errs.Add("graph", `a watcher has either a "graph" or "plugins" and "sidePlugins"`, ERROR_PLUGIN_PIPELINE)
+		return errs
+	}
+
+	nodes := c.nodes()
+	read := map[string]bool{}
+	for _, n := range nodes {
+		for _, from := range n.From {
+			read[from] = true
+		}
+	}
+
+	for _, n := range nodes {
+		path := n.path
+		if len(c.Graph) > 0 {
+			path += ".plugin"
+		}
+
+		logOnly, ok := plugins[n.Plugin]
+		if !ok {
+			errs.Add(path, fmt.Sprintf("plugin %q is not defined", n.Plugin), ERROR_PLUGIN_NOT_DEFINED)
+			continue
+		}
+
+		// in a chain the last plugin's output is the watcher's, so a
+		// linter anywhere in it replaces the compiled file
+		chained := len(c.Graph) == 0 && !n.side && len(c.PluginNames) > 1
+		if logOnly && (read[n.Name] || chained) {
+			msg := fmt.Sprintf("plugin %q only logs and would replace the output, list it in sidePlugins", n.Plugin)
+			if len(c.Graph) > 0 {
+				msg = fmt.Sprintf("plugin %q only logs, no node can read from it", n.Plugin)
+			}
+			errs.Add(path, msg, ERROR_PLUGIN_PIPELINE)
+		}
+	}
+
+	if _, err := sortNodes(nodes); err != nil {
+		errs = append(errs, err...)
+	}
+	return errs
+}
+
+// graphNode is a GraphNode with its plugin started.
+type graphNode struct {
+	plugin *Plugin
+	from   []int // indexes of the nodes read from, -1 for the input
+	side   bool
+	sink   bool // no other node reads from it
+}
+
+// newGraph starts the watcher's nodes, sorted so each comes after the
+// nodes it reads from.
+func newGraph(c *WatcherConfig, config *Config) ([]*graphNode, error) {
+	sorted, errs := sortNodes(c.nodes())
+	if errs != nil {
+		return nil, errs
+	}
+
+	index := map[string]int{GRAPH_INPUT: -1}
+	graph := []*graphNode{}
+
+	for i, n := range sorted {
+		p := config.GetPlugin(n.Plugin)
+		if p == nil {
+			msg := fmt.Sprintf("plugin %q is not defined", n.Plugin)
+			return nil, &ConfigError{Path: n.path, Message: msg, Help: ERROR_PLUGIN_NOT_DEFINED}
+		}
+
+		node := &graphNode{plugin: p, side: n.side, sink: true}
+		for _, from := range n.From {
+			j := index[from]
+			node.from = append(node.from, j)
+			if j >= 0 {
+				graph[j].sink = false
+			}
+		}
+
+		index[n.Name] = i
+		graph = append(graph, node)
+	}
+	return graph, nil
+}
+
+// mergeInputs is the file a node runs on. The outputs of several nodes
+// are joined in order, along with their source maps. A missing input
+// leaves nothing to run on, and an error or log is passed on as is.
+func mergeInputs(ins []*File) *File {
+	for _, in := range ins {
+		if in == nil || in.Op == ERROR || in.Op == LOG {
+			return in
+		}
+	}
+
+	merged := *ins[0]
+	merged.Outputs = nil
+	contents := []string{}
+	mapped := false
+	for _, in := range ins {
+		contents = append(contents, in.Content)
+		merged.Outputs = append(merged.Outputs, in.Outputs...)
+		mapped = mapped || in.Map != ""
+	}
+	merged.Content = strings.Join(contents, "\n")
+	if mapped && len(ins) > 1 {
+		merged.Map = concatMaps(ins).String()
+	}
+	return &merged
+}
+
+// withOutputs keeps the extra outputs of the stages before out, so they
+// are stored along with it.
+func withOutputs(in, out *File) *File {
+	if out == nil || in == nil || out == in || len(in.Outputs) == 0 || out.Op == ERROR {
+		return out
+	}
+	out.Outputs = append(append([]*File{}, in.Outputs...), out.Outputs...)
+	return out
+}
@@ -1,14 +1,19 @@
 package lib
 
-func reloadScript(port string) string {
+func reloadScript(addr string) string {
 	return `
 <script type='text/javascript'>
     (function() {
         var poller;
 
         function connect() {
-            var livereloadWebSocket = new WebSocket("ws://localhost:` + port + `/reload/");
+            var livereloadWebSocket = new WebSocket("ws://` + addr + `/reload/");
             livereloadWebSocket.onmessage = function(msg) {
+                if (JSON.parse(msg.data).Message === 'GOING_AWAY') {
+                  console.log('[ws] Server going away', new Date());
+                  return;
+                }
+
                 // livereloadWebSocket.close();
                 // window.location.reload(true);
 
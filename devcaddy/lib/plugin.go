@@ -1,12 +1,14 @@
 package lib
 
 import (
+	"context"
 	"encoding/json"
-	"io/ioutil"
-	"log"
-	"os/exec"
+	"fmt"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/monocle/devcaddy/devcaddy/process"
 )
@@ -15,31 +17,117 @@ var CommandMap = map[string]string{
 	".go": "go run",
 	".js": "node",
 	".rb": "ruby",
+	".py": "python3",
 }
 
 type PluginConfig struct {
 	Name, Command, Args string
-	PipeTo, Path        string
+	Path                string
 	Opts                interface{}
 	LogOnly, NoOutput   bool
+
+	// Filter skips the files it doesn't match, which are passed on as
+	// they are.
+	Filter
+	root string // project root the filter's paths are relative to
+
+	// RawArgs is "args" as written in the config. A string is read into
+	// Args, split like a shell would, and an array into ArgList, one arg
+	// per item.
+	RawArgs json.RawMessage `json:"args,omitempty"`
+	ArgList []string        `json:"-"`
+
+	// Shell runs the command and args through "sh -c", so they can use
+	// pipes and redirects. Variable values are quoted for the shell.
+	Shell bool
+
+	// Timeout is how long a file may take, ie. "10s". The command is
+	// killed and an error produced when it runs out.
+	Timeout string
+	timeout time.Duration
+
+	// Concurrency is how many files the plugin runs at once. It defaults
+	// to the CPU count, or to Workers for process plugins.
+	Concurrency int
+
+	// Workers is how many processes a process plugin starts, each given
+	// one file at a time. It defaults to 1.
+	Workers int
+
+	// Input is how a command gets the file's content, ie. "stdin", and
+	// Output where its result is read from, ie. "file". See INPUT_ARGV
+	// and OUTPUT_STDOUT.
+	Input, Output string
+
+	// Transformer is the name of a registered Transformer to run in
+	// process, instead of a command.
+	Transformer string
+
+	// NoCache runs the plugin on every file, even if its output for the
+	// same input is cached.
+	NoCache bool
+
+	// PipeTo was replaced by pipelines and graphs. It is only read to
+	// report configs still using it.
+	PipeTo string
 }
 
-func (cfg *PluginConfig) Parse() {
-	if cfg.Command == "" {
+func (cfg *PluginConfig) Parse() error {
+	if err := cfg.decodeArgs(); err != nil {
+		return err
+	}
+
+	if cfg.Transformer != "" {
+		if err := cfg.checkTransformer(); err != nil {
+			return err
+		}
+		if cfg.Name == "" {
+			cfg.Name = cfg.Transformer
+		}
+	} else if cfg.Command == "" {
 		path := cfg.Path
 		ext := filepath.Ext(path)
 		cfg.Command = CommandMap[ext]
 
 		if cfg.Command == "" {
-			log.Fatalln(cfg.Args, ERROR_PLUGIN_COMMAND_UNKNOWN)
+			msg := fmt.Sprintf("unknown command for plugin path %q", path)
+			return &ConfigError{Path: "path", Message: msg, Help: ERROR_PLUGIN_COMMAND_UNKNOWN}
+		}
+	}
+
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil || d <= 0 {
+			msg := fmt.Sprintf("invalid timeout %q", cfg.Timeout)
+			return &ConfigError{Path: "timeout", Message: msg, Help: ERROR_PLUGIN_TIMEOUT}
 		}
+		cfg.timeout = d
+	}
+
+	if cfg.Workers < 0 {
+		msg := fmt.Sprintf("invalid workers %d", cfg.Workers)
+		return &ConfigError{Path: "workers", Message: msg, Help: ERROR_PLUGIN_WORKERS}
+	}
+	if cfg.Workers > 0 && !cfg.IsProcess() {
+		msg := "workers are only for process plugins"
+		return &ConfigError{Path: "workers", Message: msg, Help: ERROR_PLUGIN_WORKERS}
+	}
+
+	if err := cfg.Filter.check().Err(); err != nil {
+		return err
+	}
+	if err := cfg.parseArgs(); err != nil {
+		return err
+	}
+	if err := cfg.parseIO(); err != nil {
+		return err
 	}
 
 	name := cfg.Name
 	if name == "" {
 		if cfg.Command != "" && cfg.Path == "" {
 			cfg.Name = cfg.Command
-			return
+			return nil
 		}
 		base := filepath.Base(cfg.Path)
 		split := strings.Split(base, ".")
@@ -50,24 +138,61 @@ func (cfg *PluginConfig) Parse() {
 		}
 		cfg.Name = name
 	}
+	return nil
+}
+
+// IsProcess is true if the plugin runs in a long lived process rather
+// than a new command per file, ie. for .js, .rb and .py paths.
+func (cfg *PluginConfig) IsProcess() bool {
+	return process.HasAdapter(CommandMap[filepath.Ext(cfg.Path)])
 }
 
 func (cfg *PluginConfig) InjectedArgs(f *File) []string {
-	argStr := cfg.Args
-	if !strings.Contains(argStr, "{{fileName}}") && !strings.Contains(argStr, "{{fileContent}}") {
-		argStr += " {{fileName}} {{fileContent}}"
+	return cfg.injectArgs(f, nil)
+}
+
+// injectArgs is InjectedArgs with the paths of the {{inputFile}} and
+// {{outputFile}} of a run. Args are split before the variables are
+// replaced, so a value is always a single arg.
+func (cfg *PluginConfig) injectArgs(f *File, paths map[string]string) []string {
+	words, _ := cfg.argWords() // checked by Parse
+
+	args := []string{}
+	for _, word := range append(words, cfg.defaultArgs()...) {
+		args = append(args, expandArg(word, f, paths))
+	}
+	return args
+}
+
+func expandArg(arg string, f *File, paths map[string]string) string {
+	for name, path := range paths {
+		arg = strings.Replace(arg, "{{"+name+"}}", path, -1)
 	}
+	return expandFileVars(arg, f)
+}
 
-	// TODO - clean then use regexp.Split
-	args := strings.Split(argStr, " ")
-	for i, arg := range args {
-		if strings.Contains(arg, "{{fileName}}") {
-			args[i] = strings.Replace(arg, "{{fileName}}", f.Name, 1)
-		} else {
-			args[i] = strings.Replace(arg, "{{fileContent}}", f.Content, 1)
+// shellScript is the command and args for "sh -c", with the values of
+// the variables quoted.
+func (cfg *PluginConfig) shellScript(f *File, paths map[string]string) string {
+	script := strings.Join(append([]string{cfg.Command, cfg.argsText()}, cfg.defaultArgs()...), " ")
+	return varRegexp.ReplaceAllStringFunc(script, func(m string) string {
+		v := expandArg(m, f, paths)
+		if v == m {
+			return m
 		}
+		return shellQuote(v)
+	})
+}
+
+// commandLine is the program to run on f and its args.
+func (cfg *PluginConfig) commandLine(f *File, paths map[string]string) (string, []string) {
+	if cfg.Shell {
+		return "sh", []string{"-c", cfg.shellScript(f, paths)}
 	}
-	return args
+
+	// the command may have args of its own, ie. "go run"
+	words, _ := splitArgs(cfg.Command)
+	return words[0], append(words[1:], cfg.injectArgs(f, paths)...)
 }
 
 type Plugin struct {
@@ -75,164 +200,331 @@ type Plugin struct {
 	Transform func(*File) *File
 	InC       chan *File
 	OutC      chan *File
+	quit      chan bool
+	onClose   func()
+
+	// run is Transform with a context that is cancelled when a newer
+	// file with the same name comes in or the plugin is closed.
+	run    func(context.Context, *File) *File
+	ctx    context.Context
+	cancel func()
+	runs   *latestRuns
+	limit  *limiter
+	pool   *Pool
+	cache  *Cache
 }
 
-func (p *Plugin) SetOutC(c chan *File) {
-	if p.PipeTo == "" {
-		p.OutC = c
+// Close stops the plugin from accepting files and ends its process, if
+// it has one.
+func (p *Plugin) Close() {
+	close(p.quit)
+	p.cancel()
+	if p.onClose != nil {
+		p.onClose()
 	}
 }
 
+func (p *Plugin) SetOutC(c chan *File) {
+	p.OutC = c
+}
+
 func (p *Plugin) listen() {
 	for {
-		in := <-p.InC
-		if in.Op == ERROR {
-			p.OutC <- in
+		var in *File
+		select {
+		case in = <-p.InC:
+		case <-p.quit:
+			return
 		}
 
-		var out *File
-
-		if !p.NoOutput {
-			go func() {
-				out = p.Transform(in)
+		if in == nil || in.Op == ERROR || !p.accepts(in) {
+			p.send(in)
+			continue
+		}
 
-				if p.LogOnly {
-					out.Op = LOG
-				}
-				p.OutC <- out
-			}()
-		} else {
-			p.OutC <- out
+		if p.NoOutput {
+			p.send(nil)
+			continue
 		}
+
+		ctx, r := p.runs.start(p.ctx, in.Name)
+		t := p.reserve(in)
+		go func() {
+			p.runs.finish(in.Name, r, p.send, p.runTicket(ctx, t, in))
+		}()
 	}
 }
 
-func NewPlugin(cfg *PluginConfig, fn func(*File) *File) *Plugin {
-	p := &Plugin{
-		PluginConfig: *cfg,
-		Transform:    fn,
-		InC:          make(chan *File),
-		OutC:         make(chan *File),
+// Run transforms f once the plugin's and the pool's limits allow it, and
+// returns the output. ERROR files and the files the filter skips are
+// returned as is. The output is nil if the plugin has none or ctx is
+// cancelled before it runs.
+func (p *Plugin) Run(ctx context.Context, f *File) *File {
+	return p.runTicket(ctx, p.reserve(f), f)
+}
+
+// reserve takes f's place in line for the plugin, so files are let in
+// the order they arrived in.
+func (p *Plugin) reserve(f *File) *ticket {
+	if f == nil || f.Op == ERROR || p.NoOutput || !p.accepts(f) {
+		return nil
 	}
+	return p.limit.enqueue(f.Scan)
+}
 
-	go p.listen()
-	return p
+// accepts is false for the files the plugin's filter skips.
+func (p *Plugin) accepts(f *File) bool {
+	return p.Filter.Matches(p.root, f.Name)
 }
 
-func NewIdentityPlugin() *Plugin {
-	return NewPlugin(&PluginConfig{}, func(f *File) *File {
-		return f
+func (p *Plugin) runTicket(ctx context.Context, t *ticket, f *File) *File {
+	if t == nil {
+		if f == nil || f.Op == ERROR || !p.accepts(f) {
+			return f
+		}
+		return nil
+	}
+
+	key := ""
+	if p.cache != nil && p.cacheable(f) {
+		key = p.cache.Key(&p.PluginConfig, f)
+		if out, ok := p.cache.Get(key, f); ok {
+			t.drop()
+			out.Scan = f.Scan
+			return out
+		}
+	}
+
+	// closing the plugin cancels the runs of every caller
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(p.ctx, cancel)
+	defer stop()
+
+	var out *File
+	p.pool.Do(ctx, t, func() {
+		out = p.run(ctx, f)
 	})
+	takeSourceMap(out)
+
+	// superseded outputs may be cut short
+	if key != "" && ctx.Err() == nil {
+		p.cache.Put(key, out)
+	}
+
+	if out != nil {
+		out.Scan = f.Scan
+		if p.LogOnly {
+			out.Op = LOG
+		}
+	}
+	return out
 }
 
-func NewCommandPlugin(cfg *PluginConfig) *Plugin {
-	cfg.Parse()
+// send passes f on, unless the plugin is closed first.
+func (p *Plugin) send(f *File) {
+	select {
+	case p.OutC <- f:
+	case <-p.quit:
+	}
+}
 
-	fn := func(f *File) *File {
-		args := cfg.InjectedArgs(f)
+// pluginRun is the latest run of a file name.
+type pluginRun struct {
+	cancel func()
+}
 
-		if f.IsDeleted() {
-			return NewFileWithContent(f.Name, "", f.Op)
-		}
+// latestRuns tracks the latest run of each file name, so that a newer
+// file cancels the run in progress and its output is dropped.
+type latestRuns struct {
+	mu   sync.Mutex
+	runs map[string]*pluginRun
+}
 
-		cmd := exec.Command(cfg.Command, args...)
-		output, err := cmd.CombinedOutput()
-		return NewFileFromCommand(f, output, err, cfg.Name)
+func newLatestRuns() *latestRuns {
+	return &latestRuns{runs: map[string]*pluginRun{}}
+}
+
+// start cancels the run in progress for name, if any.
+func (lr *latestRuns) start(parent context.Context, name string) (context.Context, *pluginRun) {
+	ctx, cancel := context.WithCancel(parent)
+	r := &pluginRun{cancel: cancel}
+
+	lr.mu.Lock()
+	if prev := lr.runs[name]; prev != nil {
+		prev.cancel()
+	}
+	lr.runs[name] = r
+	lr.mu.Unlock()
+	return ctx, r
+}
+
+// finish sends outs if r is still the latest run for name. Superseded
+// runs send nil for each instead, so each output is still counted.
+func (lr *latestRuns) finish(name string, r *pluginRun, send func(*File), outs ...*File) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	latest := lr.runs[name] == r
+	if latest {
+		delete(lr.runs, name)
+	}
+	r.cancel()
+
+	// sent while locked so a newer run can't be sent first
+	for _, out := range outs {
+		if !latest {
+			out = nil
+		}
+		send(out)
 	}
+}
 
-	return NewPlugin(cfg, fn)
+func NewPlugin(cfg *PluginConfig, fn func(*File) *File) *Plugin {
+	return newPlugin(cfg, func(ctx context.Context, f *File) *File {
+		return fn(f)
+	})
 }
 
-func NewProcessPlugin(cfg *PluginConfig) *Plugin {
-	cfg.Parse()
+// newPlugin is NewPlugin for transforms that stop when ctx is cancelled.
+func newPlugin(cfg *PluginConfig, fn func(context.Context, *File) *File) *Plugin {
+	ctx, cancel := context.WithCancel(context.Background())
 
-	pluginDef, err := ioutil.ReadFile(cfg.Path)
-	if err != nil {
-		log.Fatalln("Error reading plugin file", err)
+	limit := cfg.Concurrency
+	if limit == 0 && cfg.IsProcess() {
+		limit = cfg.workerCount()
 	}
 
-	opts := ""
-	if cfg.Opts != nil {
-		opts = cfg.Opts.(string)
+	p := &Plugin{
+		PluginConfig: *cfg,
+		Transform: func(f *File) *File {
+			return fn(ctx, f)
+		},
+		InC:    make(chan *File),
+		OutC:   make(chan *File),
+		quit:   make(chan bool),
+		run:    fn,
+		ctx:    ctx,
+		cancel: cancel,
+		runs:   newLatestRuns(),
+		limit:  newLimiter(limit),
 	}
 
-	p := process.NewProcess(cfg.Command, string(pluginDef), opts)
+	go p.listen()
+	return p
+}
 
-	fn := func(f *File) *File {
-		p.In <- process.Marshal(f.Name, f.Content)
-		out := <-p.Out
+// timeoutFile is the ERROR file produced when plugin took too long on f.
+func timeoutFile(f *File, plugin string, elapsed time.Duration) *File {
+	return &File{
+		Name:       f.Name,
+		Op:         ERROR,
+		PluginName: plugin,
+		Error:      fmt.Errorf("plugin %s timed out after %s", plugin, elapsed.Round(time.Millisecond)),
+	}
+}
 
-		output := &File{
-			Name:       f.Name,
-			Op:         f.Op,
-			PluginName: cfg.Name,
-		}
+func NewIdentityPlugin() *Plugin {
+	return NewPlugin(&PluginConfig{}, func(f *File) *File {
+		return f
+	})
+}
 
-		err = json.Unmarshal([]byte(out.Content), output)
+func NewCommandPlugin(cfg *PluginConfig) (*Plugin, error) {
+	if err := cfg.Parse(); err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			output.Error = err
+	// ctx is cancelled when the plugin is closed or the file superseded,
+	// which kills the command
+	fn := func(ctx context.Context, f *File) *File {
+		if f.IsDeleted() {
+			return NewFileWithContent(f.Name, "", f.Op)
 		}
 
-		if out.Error != nil {
-			output.Error = out.Error
-			output.Op = ERROR
+		if cfg.timeout > 0 {
+			var done func()
+			ctx, done = context.WithTimeout(ctx, cfg.timeout)
+			defer done()
 		}
 
-		return output
+		start := time.Now()
+		output, logs, err := cfg.runCommand(ctx, f)
+
+		if ctx.Err() == context.DeadlineExceeded {
+			return timeoutFile(f, cfg.Name, time.Since(start))
+		}
+		out := NewFileFromCommand(f, output, err, cfg.Name)
+		out.Logs = logs
+		return out
 	}
 
-	return NewPlugin(cfg, fn)
+	return newPlugin(cfg, fn), nil
 }
 
-func NewPlugins(pcs []*PluginConfig) *Plugins {
+// NewPlugins starts a plugin for each config. Plugins that fail to start
+// are reported under their "plugins[i]" path, and the others are closed.
+func NewPlugins(pcs []*PluginConfig) (*Plugins, error) {
 	ps := map[string]*Plugin{}
+	errs := ConfigErrors{}
 
-	for _, conf := range pcs {
-		var p *Plugin
-		if filepath.Ext(conf.Path) == ".js" {
-			p = NewProcessPlugin(conf)
-		} else {
-			p = NewCommandPlugin(conf)
+	for i, conf := range pcs {
+		path := "plugins[" + strconv.Itoa(i) + "]"
+
+		p, err := newPluginFromConfig(conf)
+		if err != nil {
+			errs.Append(path, err)
+			continue
 		}
 
 		if ps[p.Name] != nil {
-			log.Fatalln(ERROR_PLUGIN_DUPLICATE)
+			p.Close()
+			errs.Add(path+".name", fmt.Sprintf("plugin %q is defined more than once", p.Name), ERROR_PLUGIN_DUPLICATE)
+			continue
 		}
 		ps[p.Name] = p
 	}
 
-	plugins := Plugins{ps}
-
-	for _, p := range ps {
-		if p.PipeTo != "" {
-			p.OutC = plugins.Get(p.PipeTo).InC
+	if err := errs.Err(); err != nil {
+		for _, p := range ps {
+			p.Close()
 		}
+		return nil, err
 	}
+	return &Plugins{ps}, nil
+}
 
-	return &plugins
+func newPluginFromConfig(conf *PluginConfig) (*Plugin, error) {
+	if conf.Transformer != "" {
+		return NewTransformerPlugin(conf)
+	}
+	if conf.IsProcess() {
+		return NewProcessPlugin(conf)
+	}
+	return NewCommandPlugin(conf)
 }
 
 type Plugins struct {
 	content map[string]*Plugin
 }
 
+// Get returns nil if the plugin is not defined.
 func (ps *Plugins) Get(name string) *Plugin {
 	if name == "_identity_" {
 		return NewIdentityPlugin()
 	}
 
-	p := ps.content[name]
-	if p == nil {
-		log.Fatalln(ERROR_PLUGIN_NOT_DEFINED, name)
-	}
-	return p
+	return ps.content[name]
 }
 
 func (ps *Plugins) Add(p *Plugin) {
 	ps.content[p.Name] = p
 }
 
+func (ps *Plugins) Remove(name string) {
+	delete(ps.content, name)
+}
+
 func (ps *Plugins) Each(fn func(*Plugin)) int {
 	i := 0
 	for _, p := range ps.content {
@@ -1,7 +1,16 @@
 package lib
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -60,7 +69,7 @@ func TestCommandPlugin(t *testing.T) {
 
 	Convey("Given a CommandPlugin", t, func() {
 		Convey("Name and Command are parsed from Args if not given", func() {
-			p := NewCommandPlugin(&PluginConfig{
+			p, _ := NewCommandPlugin(&PluginConfig{
 				Path: "path/to/echo.js",
 				Args: "-n",
 			})
@@ -70,7 +79,7 @@ func TestCommandPlugin(t *testing.T) {
 		})
 
 		Convey("An input file's name and content is sent as params", func() {
-			p := NewCommandPlugin(&PluginConfig{
+			p, _ := NewCommandPlugin(&PluginConfig{
 				Command: "echo",
 				Args:    "-n",
 			})
@@ -82,7 +91,7 @@ func TestCommandPlugin(t *testing.T) {
 		})
 
 		Convey("Command error is added to the output", func() {
-			p := NewCommandPlugin(&PluginConfig{
+			p, _ := NewCommandPlugin(&PluginConfig{
 				Command: "node",
 				Args:    "aasdfssdf.js",
 			})
@@ -95,7 +104,7 @@ func TestCommandPlugin(t *testing.T) {
 		})
 
 		Convey("Command can change the output file's name", func() {
-			p := NewCommandPlugin(&PluginConfig{
+			p, _ := NewCommandPlugin(&PluginConfig{
 				Command: "echo",
 				Args:    "-n __SERVER_FILE_PATH__=",
 			})
@@ -106,9 +115,21 @@ func TestCommandPlugin(t *testing.T) {
 			So(res.Content, ShouldEqual, "")
 		})
 
+		Convey("Command can output several files", func() {
+			res := NewFileFromCommand(inputFile, []byte("var a;\n__SERVER_FILE_PATH__=a.js\n{}\n__SERVER_FILE_PATH__=a.js.map\nrest"), nil, "p")
+
+			So(res.Name, ShouldEqual, "a.js")
+			So(res.Content, ShouldEqual, "var a;\n")
+			So(len(res.Outputs), ShouldEqual, 2)
+			So(res.Outputs[0].Name, ShouldEqual, "a.js.map")
+			So(res.Outputs[0].Content, ShouldEqual, "{}\n")
+			So(res.Outputs[1].Name, ShouldEqual, "foo.js")
+			So(res.Outputs[1].Content, ShouldEqual, "rest")
+		})
+
 		Convey("Args can specify whether file path or content is sent - ", func() {
 			Convey("Only file path can be sent", func() {
-				p := NewCommandPlugin(&PluginConfig{
+				p, _ := NewCommandPlugin(&PluginConfig{
 					Command: "echo",
 					Args:    "-n {{fileName}}",
 				})
@@ -120,7 +141,7 @@ func TestCommandPlugin(t *testing.T) {
 			})
 
 			Convey("Only file content can be sent", func() {
-				p := NewCommandPlugin(&PluginConfig{
+				p, _ := NewCommandPlugin(&PluginConfig{
 					Command: "echo",
 					Args:    "-n {{fileContent}}",
 				})
@@ -132,7 +153,7 @@ func TestCommandPlugin(t *testing.T) {
 			})
 
 			Convey("Only additional args can be sent after {{}}", func() {
-				p := NewCommandPlugin(&PluginConfig{
+				p, _ := NewCommandPlugin(&PluginConfig{
 					Command: "echo",
 					Args:    "-n {{fileContent}} bar",
 				})
@@ -145,7 +166,7 @@ func TestCommandPlugin(t *testing.T) {
 		})
 
 		Convey("File shouldn't be processed if it was deleted", func() {
-			p := NewCommandPlugin(&PluginConfig{
+			p, _ := NewCommandPlugin(&PluginConfig{
 				Command: "echo",
 				Args:    "-n {{fileContent}} bar",
 			})
@@ -177,7 +198,7 @@ world`
 	makeTestFile(t, "tmp", "plugin.js", nodeModule, 0)
 
 	Convey("Given a ProcessPlugin", t, func() {
-		p := NewProcessPlugin(&PluginConfig{
+		p, _ := NewProcessPlugin(&PluginConfig{
 			Path: "tmp/plugin.js",
 			Opts: opts,
 		})
@@ -205,6 +226,390 @@ world`
 	})
 }
 
+func TestProcessPluginSupervision(t *testing.T) {
+	Convey("A plugin that fails to load is a config error", t, func() {
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "broken.js", `exports.plugin = function( {`, 0)
+
+		_, err := NewProcessPlugin(&PluginConfig{Path: "tmp/broken.js"})
+		ce := err.(*ConfigError)
+
+		So(ce.Path, ShouldEqual, "path")
+		So(ce.Help, ShouldEqual, ERROR_PLUGIN_START)
+		So(ce.Message, ShouldContainSubstring, "SyntaxError")
+	})
+
+	Convey("A process that crashes is restarted", t, func() {
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "crash.js", `exports.plugin = function(file) {
+			if (file.name === "crash.js") { process.exit(2); }
+			return { content: "ok" };
+		};`, 0)
+
+		p, err := NewProcessPlugin(&PluginConfig{Path: "tmp/crash.js"})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		p.InC <- &File{Name: "crash.js", Op: CREATE}
+		res := <-p.OutC
+		So(res.Op, ShouldEqual, ERROR)
+		So(res.Name, ShouldEqual, "crash.js")
+		So(res.Error.Error(), ShouldStartWith, "plugin crash crashed: the plugin process exited: exit status 2, restarting it in")
+
+		p.InC <- &File{Name: "foo.js", Op: CREATE}
+		res = <-p.OutC
+		So(res.Error, ShouldBeNil)
+		So(res.Content, ShouldEqual, "ok")
+	})
+
+	Convey("A process that keeps crashing is no longer restarted", t, func() {
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "exit.js", `exports.plugin = function() { process.exit(1); };`, 0)
+
+		p, err := NewProcessPlugin(&PluginConfig{Path: "tmp/exit.js"})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		for i := 0; i < MAX_RESTARTS; i++ {
+			p.InC <- &File{Name: "foo.js", Op: CREATE}
+			So((<-p.OutC).Op, ShouldEqual, ERROR)
+		}
+
+		p.InC <- &File{Name: "foo.js", Op: CREATE}
+		res := <-p.OutC
+		So(res.Op, ShouldEqual, ERROR)
+		So(res.Error.Error(), ShouldContainSubstring, "is no longer restarted")
+	})
+}
+
+func TestProcessPluginMessages(t *testing.T) {
+	Convey("Given a process plugin that prints and warns", t, func() {
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "lint.js", `exports.plugin = function(file) {
+			console.log("linting " + file.name);
+			if (file.content === "bad") {
+				return { errors: ["unexpected bad"] };
+			}
+			return { content: file.content, warnings: ["missing semicolon"] };
+		};`, 0)
+
+		p, err := NewProcessPlugin(&PluginConfig{Path: "tmp/lint.js"})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		Convey("Logs and warnings are kept apart from the content", func() {
+			p.InC <- &File{Name: "a.js", Content: "var a", Op: CREATE}
+			res := <-p.OutC
+
+			So(res.Error, ShouldBeNil)
+			So(res.Content, ShouldEqual, "var a")
+			So(res.Logs, ShouldResemble, []string{"linting a.js"})
+			So(res.Warnings, ShouldResemble, []string{"missing semicolon"})
+		})
+
+		Convey("Errors fail the file", func() {
+			p.InC <- &File{Name: "a.js", Content: "bad", Op: CREATE}
+			res := <-p.OutC
+
+			So(res.Op, ShouldEqual, ERROR)
+			So(res.Error.Error(), ShouldEqual, "unexpected bad")
+			So(res.Logs, ShouldResemble, []string{"linting a.js"})
+		})
+	})
+}
+
+func TestAsyncProcessPlugin(t *testing.T) {
+	Convey("An async node plugin can require files next to it", t, func() {
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "suffix.js", `module.exports = "!";`, 0)
+		makeTestFile(t, "tmp", "async.js", `var suffix = require("./suffix");
+		exports.plugin = function(file) {
+			return Promise.resolve({ content: file.content + suffix });
+		};`, 0)
+
+		p, err := NewProcessPlugin(&PluginConfig{Path: "tmp/async.js"})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		p.InC <- &File{Name: "a.js", Content: "a", Op: CREATE}
+		res := <-p.OutC
+		So(res.Error, ShouldBeNil)
+		So(res.Content, ShouldEqual, "a!")
+	})
+}
+
+func TestProcessPluginWorkers(t *testing.T) {
+	Convey("Given a process plugin with workers", t, func() {
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		// each file waits for the other one to start, or gives up
+		makeTestFile(t, "tmp", "pid.js", `var fs = require("fs");
+		exports.plugin = function(file) {
+			fs.writeFileSync(__dirname + "/started-" + file.name, "");
+			var other = __dirname + "/started-" + (file.name === "a.js" ? "b.js" : "a.js");
+			var end = Date.now() + 5000;
+			while (!fs.existsSync(other)) {
+				if (Date.now() > end) return { content: "alone" };
+			}
+			return { name: file.name, content: String(process.pid) };
+		};`, 0)
+
+		p, err := NewProcessPlugin(&PluginConfig{Path: "tmp/pid.js", Workers: 2})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		Convey("Files are handled by the processes at once", func() {
+			p.InC <- &File{Name: "a.js", Op: CREATE}
+			p.InC <- &File{Name: "b.js", Op: CREATE}
+			res, res2 := <-p.OutC, <-p.OutC
+
+			So(res.Content, ShouldNotEqual, "alone")
+			So(res2.Content, ShouldNotEqual, "alone")
+			So(res.Error, ShouldBeNil)
+			So(res2.Error, ShouldBeNil)
+			So(res.Content, ShouldNotEqual, res2.Content)
+		})
+	})
+
+	Convey("Workers must be positive and on process plugins", t, func() {
+		pc := PluginConfig{Path: "plugin.js", Workers: -1}
+		err := pc.Parse().(*ConfigError)
+		So(err.Path, ShouldEqual, "workers")
+		So(err.Help, ShouldEqual, ERROR_PLUGIN_WORKERS)
+
+		pc = PluginConfig{Command: "echo", Workers: 2}
+		err = pc.Parse().(*ConfigError)
+		So(err.Message, ShouldEqual, "workers are only for process plugins")
+	})
+}
+
+func TestSupersededRuns(t *testing.T) {
+	Convey("Given a plugin that holds some contents until released", t, func() {
+		release := make(chan bool)
+		p := NewPlugin(&PluginConfig{Concurrency: 2}, func(f *File) *File {
+			if f.Content == "old" {
+				<-release
+			}
+			return &File{Name: f.Name, Content: f.Content + "!"}
+		})
+		defer p.Close()
+
+		Convey("Only the output of the latest file with a name is sent", func() {
+			p.InC <- &File{Name: "a.js", Content: "old", Op: WRITE}
+			p.InC <- &File{Name: "a.js", Content: "new", Op: WRITE}
+
+			So((<-p.OutC).Content, ShouldEqual, "new!")
+			close(release)
+			So(<-p.OutC, ShouldBeNil)
+		})
+
+		Convey("Files with other names are not affected", func() {
+			p.InC <- &File{Name: "a.js", Content: "old", Op: WRITE}
+			p.InC <- &File{Name: "b.js", Content: "new", Op: WRITE}
+
+			So((<-p.OutC).Content, ShouldEqual, "new!")
+			close(release)
+			So((<-p.OutC).Content, ShouldEqual, "old!")
+		})
+	})
+
+	Convey("A superseded command is killed", t, func() {
+		// the outputs only come before the test times out if it is killed
+		p, _ := NewCommandPlugin(&PluginConfig{Command: "sleep", Args: "{{fileContent}}"})
+		defer p.Close()
+
+		p.InC <- &File{Name: "a", Content: "600", Op: WRITE}
+		p.InC <- &File{Name: "a", Content: "0", Op: WRITE}
+
+		// the killed run may finish first
+		res, res2 := <-p.OutC, <-p.OutC
+		if res == nil {
+			res, res2 = res2, res
+		}
+
+		So(res.Op, ShouldEqual, WRITE)
+		So(res2, ShouldBeNil)
+	})
+}
+
+func TestPluginConcurrency(t *testing.T) {
+	Convey("Given a plugin that tracks how many files it runs at once", t, func() {
+		var mu sync.Mutex
+		running, max := 0, 0
+		order := []string{}
+		started := make(chan bool, 6)
+
+		fn := func(f *File) *File {
+			mu.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			order = append(order, f.Name)
+			mu.Unlock()
+			started <- true
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return f
+		}
+
+		Convey("The plugin's concurrency is respected", func() {
+			p := NewPlugin(&PluginConfig{Concurrency: 2}, fn)
+			defer p.Close()
+
+			for i := 0; i < 6; i++ {
+				p.InC <- &File{Name: strconv.Itoa(i), Op: CREATE, Scan: true}
+			}
+			for i := 0; i < 6; i++ {
+				<-p.OutC
+			}
+			So(max, ShouldEqual, 2)
+		})
+
+		Convey("The pool limits all of its plugins together", func() {
+			pool := NewPool(1)
+			p, p2 := NewPlugin(&PluginConfig{Concurrency: 2}, fn), NewPlugin(&PluginConfig{Concurrency: 2}, fn)
+			defer p.Close()
+			defer p2.Close()
+			p.pool, p2.pool = pool, pool
+
+			p.InC <- &File{Name: "a", Op: CREATE}
+			p2.InC <- &File{Name: "b", Op: CREATE}
+			<-p.OutC
+			<-p2.OutC
+			So(max, ShouldEqual, 1)
+		})
+
+		Convey("Changed files go ahead of scanned files", func() {
+			p := NewPlugin(&PluginConfig{Concurrency: 1}, fn)
+			defer p.Close()
+
+			for i := 0; i < 3; i++ {
+				p.InC <- &File{Name: "scan" + strconv.Itoa(i), Op: CREATE, Scan: true}
+			}
+			<-started
+			p.InC <- &File{Name: "save", Op: WRITE}
+
+			for i := 0; i < 4; i++ {
+				<-p.OutC
+			}
+			So(order[1], ShouldEqual, "save")
+		})
+	})
+}
+
+func TestPluginTimeout(t *testing.T) {
+	Convey("A command that runs out of time is killed", t, func() {
+		// the output only comes before the test times out if it is killed
+		p, err := NewCommandPlugin(&PluginConfig{Command: "sleep", Args: "600 {{fileName}}", Timeout: "100ms"})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		p.InC <- &File{Name: "1", Op: CREATE}
+		res := <-p.OutC
+
+		So(res.Op, ShouldEqual, ERROR)
+		So(res.PluginName, ShouldEqual, "sleep")
+		So(res.Error.Error(), ShouldStartWith, "plugin sleep timed out after ")
+	})
+
+	Convey("A process that runs out of time is replaced", t, func() {
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "hang.js", `exports.plugin = function(file) {
+			while (file.name === "hang.js") {}
+			return { content: "ok" };
+		};`, 0)
+
+		p, err := NewProcessPlugin(&PluginConfig{Path: "tmp/hang.js", Timeout: "200ms"})
+		So(err, ShouldBeNil)
+		defer p.Close()
+
+		p.InC <- &File{Name: "hang.js", Op: CREATE}
+		res := <-p.OutC
+		So(res.Op, ShouldEqual, ERROR)
+		So(res.Error.Error(), ShouldContainSubstring, "plugin hang timed out after")
+
+		p.InC <- &File{Name: "foo.js", Op: CREATE}
+		res = <-p.OutC
+		So(res.Error, ShouldBeNil)
+		So(res.Content, ShouldEqual, "ok")
+	})
+
+	Convey("An invalid timeout is a config error", t, func() {
+		pc := PluginConfig{Command: "echo", Timeout: "soon"}
+		err := pc.Parse().(*ConfigError)
+
+		So(err.Path, ShouldEqual, "timeout")
+		So(err.Help, ShouldEqual, ERROR_PLUGIN_TIMEOUT)
+	})
+}
+
+func TestPluginIO(t *testing.T) {
+	inputFile := createFile()
+
+	Convey("Given command plugins with input and output modes", t, func() {
+		run := func(pc *PluginConfig) *File {
+			p, err := NewCommandPlugin(pc)
+			So(err, ShouldBeNil)
+			defer p.Close()
+			return p.Run(context.Background(), inputFile)
+		}
+
+		Convey("stdin pipes the content in", func() {
+			res := run(&PluginConfig{Command: "tr", Args: "a-z A-Z", Input: "stdin"})
+			So(res.Content, ShouldEqual, "HELLO")
+		})
+
+		Convey("tempfile writes the content to a file named like the input", func() {
+			res := run(&PluginConfig{Command: "cat", Input: "tempfile"})
+			So(res.Content, ShouldEqual, "hello")
+
+			res = run(&PluginConfig{Command: "basename", Args: "{{inputFile}}", Input: "tempfile"})
+			So(res.Content, ShouldEqual, "foo.js\n")
+		})
+
+		Convey("file reads the output from a file", func() {
+			res := run(&PluginConfig{Command: "cp", Args: "{{inputFile}} {{outputFile}}", Input: "tempfile", Output: "file"})
+			So(res.Error, ShouldBeNil)
+			So(res.Content, ShouldEqual, "hello")
+
+			res = run(&PluginConfig{Name: "lazy", Command: "true", Output: "file"})
+			So(res.Op, ShouldEqual, ERROR)
+			So(res.Error.Error(), ShouldEqual, "plugin lazy wrote no output file")
+		})
+
+		Convey("stderr is logged instead of joining the output", func() {
+			res := run(&PluginConfig{Command: "echo careful >&2; tr a-z A-Z", Input: "stdin", Shell: true})
+			So(res.Error, ShouldBeNil)
+			So(res.Content, ShouldEqual, "HELLO")
+			So(res.Logs, ShouldResemble, []string{"careful"})
+
+			res = run(&PluginConfig{Command: "echo broken >&2; exit 1", Input: "stdin", Shell: true})
+			So(res.Op, ShouldEqual, ERROR)
+			So(res.Error.Error(), ShouldEqual, "broken")
+		})
+
+		Convey("Unknown modes and their variables out of place are config errors", func() {
+			err := (&PluginConfig{Command: "cat", Input: "pipe"}).Parse().(*ConfigError)
+			So(err.Path, ShouldEqual, "input")
+			So(err.Help, ShouldEqual, ERROR_PLUGIN_IO)
+
+			err = (&PluginConfig{Command: "cat", Args: "{{outputFile}}"}).Parse().(*ConfigError)
+			So(err.Path, ShouldEqual, "args")
+		})
+	})
+}
+
 func TestNewPlugins(t *testing.T) {
 	Convey("creatPlugins correcly creates the Plugins", t, func() {
 		pcs := []*PluginConfig{
@@ -213,15 +618,9 @@ func TestNewPlugins(t *testing.T) {
 				Command: "echo",
 				Args:    "-n {{fileContent}}1",
 			},
-			&PluginConfig{
-				Name:    "template",
-				Command: "echo",
-				Args:    "-n {{fileContent}}2",
-				PipeTo:  "transpile",
-			},
 		}
 
-		plugins := NewPlugins(pcs)
+		plugins, _ := NewPlugins(pcs)
 
 		Convey("Names should be correct", func() {
 			p := plugins.Get("transpile")
@@ -237,14 +636,6 @@ func TestNewPlugins(t *testing.T) {
 			So(res.Content, ShouldEqual, "hello1")
 		})
 
-		Convey("Plugins that pipe to another plugin have their OutC set", func() {
-			p := plugins.Get("template")
-			p.InC <- &File{Name: "foo.js", Content: "hello"}
-			res := <-plugins.Get("transpile").OutC
-
-			So(res.Name, ShouldEqual, "foo.js")
-			So(res.Content, ShouldEqual, "hello21")
-		})
 	})
 }
 
@@ -297,3 +688,134 @@ func TestPluginConf(t *testing.T) {
 		})
 	})
 }
+
+func TestScriptProcessPlugins(t *testing.T) {
+	Convey("Python plugins run in a process kept between files", t, func() {
+		if _, err := exec.LookPath("python3"); err != nil {
+			SkipSo("python3 is not installed")
+			return
+		}
+
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "count.py", `print("loading")
+count = 0
+
+def plugin(file, settings):
+    global count
+    count += 1
+    return {'content': file['content'] + settings['suffix'] + str(count)}
+`, 0)
+
+		pc := &PluginConfig{Path: "tmp/count.py", Opts: `{"suffix": "-"}`}
+		So(pc.IsProcess(), ShouldBeTrue)
+
+		plugins, err := NewPlugins([]*PluginConfig{pc})
+		So(err, ShouldBeNil)
+		p := plugins.Get("count")
+		defer p.Close()
+
+		p.InC <- &File{Name: "a.py", Content: "a", Op: CREATE}
+		So((<-p.OutC).Content, ShouldEqual, "a-1")
+		p.InC <- &File{Name: "b.py", Content: "b", Op: CREATE}
+		So((<-p.OutC).Content, ShouldEqual, "b-2")
+	})
+
+	Convey("Ruby plugins run in a process kept between files", t, func() {
+		if _, err := exec.LookPath("ruby"); err != nil {
+			SkipSo("ruby is not installed")
+			return
+		}
+
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "count.rb", `puts "loading"
+
+def plugin(file, settings)
+  @count = (@count || 0) + 1
+  { 'content' => file['content'] + settings['suffix'] + @count.to_s }
+end
+`, 0)
+
+		pc := &PluginConfig{Path: "tmp/count.rb", Opts: `{"suffix": "-"}`}
+		So(pc.IsProcess(), ShouldBeTrue)
+
+		plugins, err := NewPlugins([]*PluginConfig{pc})
+		So(err, ShouldBeNil)
+		p := plugins.Get("count")
+		defer p.Close()
+
+		p.InC <- &File{Name: "a.rb", Content: "a", Op: CREATE}
+		So((<-p.OutC).Content, ShouldEqual, "a-1")
+		p.InC <- &File{Name: "b.rb", Content: "b", Op: CREATE}
+		So((<-p.OutC).Content, ShouldEqual, "b-2")
+	})
+
+	Convey("Object opts are given to the plugin as its settings", t, func() {
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "opts.js", `exports.plugin = function(file, settings) {
+			return { content: file.content + settings.suffix + settings.nested.n };
+		};`, 0)
+
+		var opts interface{}
+		So(json.Unmarshal([]byte(`{"suffix": "-", "nested": {"n": 2}}`), &opts), ShouldBeNil)
+
+		plugins, err := NewPlugins([]*PluginConfig{{Path: "tmp/opts.js", Opts: opts}})
+		So(err, ShouldBeNil)
+		p := plugins.Get("opts")
+		defer p.Close()
+
+		p.InC <- &File{Name: "a.js", Content: "a", Op: CREATE}
+		So((<-p.OutC).Content, ShouldEqual, "a-2")
+	})
+
+	Convey("Ruby and Python paths are process plugins", t, func() {
+		So((&PluginConfig{Path: "a.rb"}).IsProcess(), ShouldBeTrue)
+		So((&PluginConfig{Path: "a.py"}).IsProcess(), ShouldBeTrue)
+		So((&PluginConfig{Path: "a.go"}).IsProcess(), ShouldBeFalse)
+		So((&PluginConfig{Command: "ruby", Args: "a.rb"}).IsProcess(), ShouldBeFalse)
+	})
+}
+
+func TestNewPluginsErrors(t *testing.T) {
+	Convey("NewPlugins reports every plugin that can't be started", t, func() {
+		_, err := NewPlugins([]*PluginConfig{
+			&PluginConfig{Name: "ok", Command: "echo"},
+			&PluginConfig{Path: "nope.unknown"},
+			&PluginConfig{Path: "tmp/missing.js"},
+			&PluginConfig{Name: "ok", Command: "echo"},
+		})
+		errs := err.(ConfigErrors)
+
+		So(len(errs), ShouldEqual, 3)
+		So(errs[0].Path, ShouldEqual, "plugins[1].path")
+		So(errs[0].Help, ShouldEqual, ERROR_PLUGIN_COMMAND_UNKNOWN)
+		So(errs[1].Path, ShouldEqual, "plugins[2].path")
+		So(errs[2].Path, ShouldEqual, "plugins[3].name")
+	})
+
+	Convey("The plugins already started are closed when one fails", t, func() {
+		makeTestDir(t, "tmp")
+		defer removeTestDir(t, "tmp")
+		makeTestFile(t, "tmp", "pid.js", `require('fs').writeFileSync(__dirname + '/pid', String(process.pid));
+		exports.plugin = function(file) { return file.content; };`, 0)
+
+		_, err := NewPlugins([]*PluginConfig{
+			&PluginConfig{Path: "tmp/pid.js"},
+			&PluginConfig{Path: "nope.unknown"},
+		})
+		So(err, ShouldNotBeNil)
+
+		b, err := ioutil.ReadFile("tmp/pid")
+		So(err, ShouldBeNil)
+		pid, _ := strconv.Atoi(string(b))
+		proc, _ := os.FindProcess(pid)
+		So(proc.Signal(syscall.Signal(0)), ShouldNotBeNil)
+	})
+
+	Convey("Get returns nil for plugins that aren't defined", t, func() {
+		plugins, _ := NewPlugins([]*PluginConfig{})
+		So(plugins.Get("nope"), ShouldBeNil)
+	})
+}
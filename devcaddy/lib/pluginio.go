@@ -0,0 +1,176 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// How a command plugin gets the file's content, set by its "input".
+const (
+	INPUT_ARGV     = "argv"     // as the {{fileContent}} argument
+	INPUT_STDIN    = "stdin"    // piped in
+	INPUT_TEMPFILE = "tempfile" // written to a file, at {{inputFile}}
+)
+
+// Where a command plugin's output is read from, set by its "output".
+const (
+	OUTPUT_STDOUT = "stdout" // stdout, stderr is logged unless the input is argv
+	OUTPUT_FILE   = "file"   // the file at {{outputFile}}
+)
+
+// parseIO checks the input and output modes and the variables they
+// provide.
+func (cfg *PluginConfig) parseIO() error {
+	if cfg.Input == "" && cfg.Output == "" {
+		if strings.Contains(cfg.argsText(), "{{inputFile}}") || strings.Contains(cfg.argsText(), "{{outputFile}}") {
+			return &ConfigError{Path: "args", Message: `{{inputFile}} needs "input": "tempfile" and {{outputFile}} "output": "file"`, Help: ERROR_PLUGIN_IO}
+		}
+		return nil
+	}
+
+	if cfg.Transformer != "" || cfg.IsProcess() {
+		return &ConfigError{Path: "input", Message: "input and output modes only apply to command plugins", Help: ERROR_PLUGIN_IO}
+	}
+
+	switch cfg.Input {
+	case "", INPUT_ARGV, INPUT_STDIN, INPUT_TEMPFILE:
+	default:
+		return &ConfigError{Path: "input", Message: fmt.Sprintf("unknown input mode %q", cfg.Input), Help: ERROR_PLUGIN_IO}
+	}
+	switch cfg.Output {
+	case "", OUTPUT_STDOUT, OUTPUT_FILE:
+	default:
+		return &ConfigError{Path: "output", Message: fmt.Sprintf("unknown output mode %q", cfg.Output), Help: ERROR_PLUGIN_IO}
+	}
+
+	if cfg.Input != INPUT_TEMPFILE && strings.Contains(cfg.argsText(), "{{inputFile}}") {
+		return &ConfigError{Path: "args", Message: `{{inputFile}} needs "input": "tempfile"`, Help: ERROR_PLUGIN_IO}
+	}
+	if cfg.Output != OUTPUT_FILE && strings.Contains(cfg.argsText(), "{{outputFile}}") {
+		return &ConfigError{Path: "args", Message: `{{outputFile}} needs "output": "file"`, Help: ERROR_PLUGIN_IO}
+	}
+	return nil
+}
+
+// defaultArgs are added to the args that don't say where the file goes.
+func (cfg *PluginConfig) defaultArgs() []string {
+	text := cfg.argsText()
+	args := []string{}
+	switch cfg.Input {
+	case "", INPUT_ARGV:
+		if !strings.Contains(text, "{{fileName}}") && !strings.Contains(text, "{{fileContent}}") {
+			args = append(args, "{{fileName}}", "{{fileContent}}")
+		}
+	case INPUT_TEMPFILE:
+		if !strings.Contains(text, "{{inputFile}}") {
+			args = append(args, "{{inputFile}}")
+		}
+	}
+
+	if cfg.Output == OUTPUT_FILE && !strings.Contains(text, "{{outputFile}}") {
+		args = append(args, "{{outputFile}}")
+	}
+	return args
+}
+
+// commandIO is where a command run reads and writes the file.
+type commandIO struct {
+	dir        string // temp dir, removed by cleanup
+	inputFile  string
+	outputFile string
+}
+
+// newCommandIO writes f to a temp file and picks the output file, as the
+// modes require.
+func (cfg *PluginConfig) newCommandIO(f *File) (*commandIO, error) {
+	cio := &commandIO{}
+	if cfg.Input != INPUT_TEMPFILE && cfg.Output != OUTPUT_FILE {
+		return cio, nil
+	}
+
+	dir, err := ioutil.TempDir("", "devcaddy-")
+	if err != nil {
+		return nil, err
+	}
+	cio.dir = dir
+
+	// named after f, as tools often go by the extension
+	base := filepath.Base(f.Name)
+	if cfg.Input == INPUT_TEMPFILE {
+		cio.inputFile = filepath.Join(dir, base)
+		if err := ioutil.WriteFile(cio.inputFile, []byte(f.Content), 0600); err != nil {
+			cio.cleanup()
+			return nil, err
+		}
+	}
+	if cfg.Output == OUTPUT_FILE {
+		cio.outputFile = filepath.Join(dir, "output"+filepath.Ext(base))
+	}
+	return cio, nil
+}
+
+func (cio *commandIO) cleanup() {
+	if cio.dir != "" {
+		os.RemoveAll(cio.dir)
+	}
+}
+
+// runCommand runs the plugin's command on f and returns its output, read
+// as the modes say, and the lines it wrote to stderr. When the command
+// fails its stderr is the error instead. Commands given the content as an
+// argument write stderr into their output, as they always have.
+func (cfg *PluginConfig) runCommand(ctx context.Context, f *File) ([]byte, []string, error) {
+	cio, err := cfg.newCommandIO(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cio.cleanup()
+
+	name, args := cfg.commandLine(f, map[string]string{
+		"inputFile":  cio.inputFile,
+		"outputFile": cio.outputFile,
+	})
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	if cfg.Input == INPUT_STDIN {
+		cmd.Stdin = strings.NewReader(f.Content)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if cfg.Input == "" || cfg.Input == INPUT_ARGV {
+		cmd.Stderr = &stdout
+	}
+
+	err = cmd.Run()
+	msg := strings.TrimSpace(stderr.String())
+	if err != nil {
+		// a killed command keeps its error, ie. for timeouts
+		if msg != "" && ctx.Err() == nil {
+			err = errors.New(msg)
+		}
+		return stdout.Bytes(), nil, err
+	}
+
+	var logs []string
+	if msg != "" {
+		logs = strings.Split(msg, "\n")
+	}
+	if cfg.Output != OUTPUT_FILE {
+		return stdout.Bytes(), logs, nil
+	}
+
+	b, err := ioutil.ReadFile(cio.outputFile)
+	if os.IsNotExist(err) {
+		return stdout.Bytes(), logs, fmt.Errorf("plugin %s wrote no output file", cfg.Name)
+	}
+	return b, logs, err
+}
@@ -0,0 +1,386 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/monocle/devcaddy/devcaddy/process"
+)
+
+// A crashed process is restarted after RESTART_BACKOFF, doubled for each
+// crash in a row up to MAX_RESTART_BACKOFF. Once the plugin's processes
+// crashed MAX_RESTARTS times within RESTART_WINDOW they are no longer
+// restarted.
+const (
+	RESTART_BACKOFF     = 100 * time.Millisecond
+	MAX_RESTART_BACKOFF = 10 * time.Second
+	MAX_RESTARTS        = 5
+	RESTART_WINDOW      = time.Minute
+)
+
+func NewProcessPlugin(cfg *PluginConfig) (*Plugin, error) {
+	if err := cfg.Parse(); err != nil {
+		return nil, err
+	}
+
+	pluginDef, err := ioutil.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, &ConfigError{Path: "path", Message: err.Error(), Help: ERROR_PLUGIN_PATH}
+	}
+
+	opts, err := processSettings(cfg.Opts)
+	if err != nil {
+		return nil, &ConfigError{Path: "opts", Message: err.Error()}
+	}
+
+	pp := &processPlugin{cfg: cfg, def: string(pluginDef), opts: opts}
+
+	// a plugin that can't load is reported before any file is sent
+	if err := pp.startWorkers(cfg.workerCount()); err != nil {
+		return nil, &ConfigError{Path: "path", Message: err.Error(), Help: ERROR_PLUGIN_START}
+	}
+
+	plugin := newPlugin(cfg, pp.transform)
+	plugin.onClose = pp.close
+	return plugin, nil
+}
+
+// processSettings are the plugin's opts as the JSON its process reads.
+// Opts may already be given as a JSON string.
+func processSettings(opts interface{}) (string, error) {
+	switch o := opts.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return o, nil
+	}
+	b, err := json.Marshal(opts)
+	return string(b), err
+}
+
+// workerCount is how many processes a process plugin runs, 1 by default.
+func (cfg *PluginConfig) workerCount() int {
+	if cfg.Workers > 0 {
+		return cfg.Workers
+	}
+	return 1
+}
+
+// processPlugin sends files to long lived processes, one file at a time
+// per process. A process that times out is killed and replaced, one that
+// crashes is restarted with a growing delay.
+type processPlugin struct {
+	cfg       *PluginConfig
+	def, opts string
+	idle      chan *processWorker // workers free for a file
+
+	mu      sync.Mutex
+	workers []*processWorker
+	closed  bool
+	crashes []time.Time // recent crashes, to back off and give up
+	failed  error       // set once the processes are no longer restarted
+}
+
+// processWorker holds one of the plugin's processes, nil while it is
+// restarted.
+type processWorker struct {
+	proc  *process.Process
+	ready chan bool // closed once proc is set
+}
+
+func (pp *processPlugin) start() (*process.Process, error) {
+	return process.NewPluginProcess(pp.cfg.Command, pp.cfg.Path, pp.def, pp.opts)
+}
+
+// startWorkers starts n processes at once. If one fails, the others are
+// killed.
+func (pp *processPlugin) startWorkers(n int) error {
+	procs := make([]*process.Process, n)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+	for i := range procs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			procs[i], errs[i] = pp.start()
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			for _, proc := range procs {
+				if proc != nil {
+					proc.Kill()
+				}
+			}
+			return err
+		}
+	}
+
+	pp.idle = make(chan *processWorker, n)
+	for _, proc := range procs {
+		w := &processWorker{ready: make(chan bool)}
+		w.set(proc)
+		pp.workers = append(pp.workers, w)
+		pp.idle <- w
+	}
+	return nil
+}
+
+// set makes proc the running process and lets the waiting file in.
+func (w *processWorker) set(proc *process.Process) {
+	w.proc = proc
+	close(w.ready)
+}
+
+// current waits for the running process of w. It fails once the plugin
+// is closed or given up on, or ctx is cancelled.
+func (pp *processPlugin) current(ctx context.Context, w *processWorker) (*process.Process, error) {
+	for {
+		pp.mu.Lock()
+		switch {
+		case pp.closed:
+			pp.mu.Unlock()
+			return nil, errors.New("the plugin was stopped")
+		case pp.failed != nil:
+			pp.mu.Unlock()
+			return nil, pp.failed
+		case w.proc != nil:
+			proc := w.proc
+			pp.mu.Unlock()
+			return proc, nil
+		}
+		ready := w.ready
+		pp.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// replace kills proc and starts a new process, unless that was already
+// done.
+func (pp *processPlugin) replace(w *processWorker, proc *process.Process) {
+	pp.restart(w, proc, 0)
+}
+
+// crashed restarts proc once its backoff is over, unless the processes
+// crashed too often. It returns the error for the file being processed.
+func (pp *processPlugin) crashed(w *processWorker, proc *process.Process) error {
+	err := fmt.Errorf("plugin %s crashed: %s", pp.cfg.Name, proc.ExitError())
+
+	pp.mu.Lock()
+	if w.proc != proc {
+		pp.mu.Unlock()
+		return err
+	}
+
+	now := time.Now()
+	recent := []time.Time{}
+	for _, t := range pp.crashes {
+		if now.Sub(t) < RESTART_WINDOW {
+			recent = append(recent, t)
+		}
+	}
+	pp.crashes = append(recent, now)
+	crashes := len(pp.crashes)
+	pp.mu.Unlock()
+
+	if crashes >= MAX_RESTARTS {
+		pp.giveUp(fmt.Errorf("plugin %s crashed %d times within %s and is no longer restarted, fix it and reload the config", pp.cfg.Name, crashes, RESTART_WINDOW))
+		return err
+	}
+
+	backoff := RESTART_BACKOFF << uint(crashes-1)
+	if backoff > MAX_RESTART_BACKOFF {
+		backoff = MAX_RESTART_BACKOFF
+	}
+	pp.restart(w, proc, backoff)
+	return fmt.Errorf("%s, restarting it in %s", err, backoff)
+}
+
+// restart replaces proc by a new process after delay. The file given to
+// w waits for the new process meanwhile, and gets an error if it fails to
+// start.
+func (pp *processPlugin) restart(w *processWorker, proc *process.Process, delay time.Duration) {
+	pp.mu.Lock()
+	if pp.closed || w.proc != proc {
+		pp.mu.Unlock()
+		return
+	}
+	w.proc = nil
+	w.ready = make(chan bool)
+	pp.mu.Unlock()
+
+	proc.Kill()
+
+	go func() {
+		time.Sleep(delay)
+		next, err := pp.start()
+
+		pp.mu.Lock()
+		defer pp.mu.Unlock()
+		if pp.closed || pp.failed != nil {
+			if next != nil {
+				next.Kill()
+			}
+			return
+		}
+		if err != nil {
+			pp.failed = fmt.Errorf("plugin %s could not be restarted: %s", pp.cfg.Name, err)
+			pp.stopWorkers()
+			return
+		}
+		w.set(next)
+	}()
+}
+
+// giveUp stops restarting the processes, every file gets err instead.
+func (pp *processPlugin) giveUp(err error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	if pp.failed == nil {
+		pp.failed = err
+	}
+	pp.stopWorkers()
+}
+
+func (pp *processPlugin) close() {
+	pp.mu.Lock()
+	pp.closed = true
+	procs := pp.stopWorkers()
+	pp.mu.Unlock()
+
+	for _, proc := range procs {
+		proc.Close()
+	}
+}
+
+// stopWorkers takes the processes from the workers and wakes the files
+// waiting for one. The processes are killed, unless the plugin is closed
+// and they are returned to be closed gently. pp.mu must be held.
+func (pp *processPlugin) stopWorkers() []*process.Process {
+	procs := []*process.Process{}
+	for _, w := range pp.workers {
+		if w.proc != nil {
+			procs = append(procs, w.proc)
+			w.proc = nil
+		}
+		select {
+		case <-w.ready:
+		default:
+			close(w.ready)
+		}
+	}
+
+	if !pp.closed {
+		for _, proc := range procs {
+			proc.Kill()
+		}
+	}
+	return procs
+}
+
+// stopped is true if proc exited because it was closed or killed.
+func stopped(proc *process.Process) bool {
+	select {
+	case <-proc.Closed():
+		return true
+	default:
+		return false
+	}
+}
+
+// transform gives f to the first free process. Files cancelled before
+// then are skipped, the output of the others is discarded by the plugin.
+func (pp *processPlugin) transform(ctx context.Context, f *File) *File {
+	output := &File{
+		Name:       f.Name,
+		Op:         f.Op,
+		PluginName: pp.cfg.Name,
+	}
+	fail := func(err error) *File {
+		output.Op = ERROR
+		output.Error = err
+		return output
+	}
+
+	var w *processWorker
+	select {
+	case w = <-pp.idle:
+		defer func() { pp.idle <- w }()
+	case <-ctx.Done():
+		return fail(ctx.Err())
+	}
+
+	var out *process.Output
+	for out == nil {
+		if ctx.Err() != nil {
+			return fail(ctx.Err())
+		}
+
+		proc, err := pp.current(ctx, w)
+		if err != nil {
+			return fail(err)
+		}
+
+		// the process handles one file at a time, the timeout starts
+		// once it gets this one
+		select {
+		case proc.In <- process.NewRequest(f.Name, f.Content):
+		case <-proc.Closed():
+			continue
+		case <-proc.Exited():
+			// crashed on an earlier file, wait for the next process
+			pp.crashed(w, proc)
+			continue
+		case <-ctx.Done():
+			return fail(ctx.Err())
+		}
+
+		var timeout <-chan time.Time
+		if pp.cfg.timeout > 0 {
+			timeout = time.After(pp.cfg.timeout)
+		}
+		start := time.Now()
+
+		select {
+		case out = <-proc.Out:
+		case <-proc.Closed():
+			// closed with the plugin
+		case <-proc.Exited():
+			if !stopped(proc) {
+				return fail(pp.crashed(w, proc))
+			}
+		case <-timeout:
+			pp.replace(w, proc)
+			return timeoutFile(f, pp.cfg.Name, time.Since(start))
+		}
+	}
+
+	if out.Name != "" {
+		output.Name = out.Name
+	}
+	output.Content = out.Content
+	output.Map = out.Map
+	output.Warnings = out.Warnings
+	output.Logs = out.Logs
+
+	if out.Error != nil {
+		output.Error = out.Error
+		output.Op = ERROR
+	}
+
+	return output
+}
@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// QUEUE_LOG_INTERVAL is how often the queue depth is logged while files
+// are waiting for a worker.
+const QUEUE_LOG_INTERVAL = time.Second
+
+// Pool limits how many plugin runs happen at once across all plugins.
+// Each plugin has its own limit too. Files sent by the initial scan wait
+// behind files that changed since.
+type Pool struct {
+	Log *Logger
+
+	global    *limiter
+	mu        sync.Mutex
+	waiting   int
+	reporting bool
+}
+
+// NewPool allows size runs at once, or one per CPU if size is 0.
+func NewPool(size int) *Pool {
+	return &Pool{global: newLimiter(size)}
+}
+
+// SetSize changes the number of runs allowed at once.
+func (pl *Pool) SetSize(size int) {
+	pl.global.setSize(size)
+}
+
+// Waiting is the number of runs waiting for a worker.
+func (pl *Pool) Waiting() int {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.waiting
+}
+
+// Do runs fn once both the plugin's place in line, t, and the pool allow
+// it. fn is not run if ctx is cancelled first. A nil Pool only applies
+// the plugin's limit.
+func (pl *Pool) Do(ctx context.Context, t *ticket, fn func()) error {
+	pl.wait(1)
+	err := t.wait(ctx)
+	if err == nil && pl != nil {
+		if err = pl.global.enqueue(t.scan).wait(ctx); err != nil {
+			t.l.release()
+		}
+	}
+	pl.wait(-1)
+
+	if err != nil {
+		return err
+	}
+
+	fn()
+	if pl != nil {
+		pl.global.release()
+	}
+	t.l.release()
+	return nil
+}
+
+func (pl *Pool) wait(n int) {
+	if pl == nil {
+		return
+	}
+
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.waiting += n
+
+	if pl.waiting > 0 && !pl.reporting {
+		pl.reporting = true
+		go pl.report()
+	}
+}
+
+// report logs the queue depth until it is empty.
+func (pl *Pool) report() {
+	for {
+		time.Sleep(QUEUE_LOG_INTERVAL)
+
+		pl.mu.Lock()
+		waiting := pl.waiting
+		if waiting == 0 {
+			pl.reporting = false
+		}
+		pl.mu.Unlock()
+
+		if waiting == 0 {
+			return
+		}
+		pl.Log.PrintC("queue", fmt.Sprintf("%d file(s) waiting, %d running", waiting, pl.global.runningCount()))
+	}
+}
+
+// limiter allows size holders at once. Waiting scan files are only let
+// in when no other file is waiting.
+type limiter struct {
+	mu      sync.Mutex
+	size    int
+	running int
+	queues  [2][]chan bool // changed files, then scan files
+}
+
+func newLimiter(size int) *limiter {
+	l := &limiter{}
+	l.setSize(size)
+	return l
+}
+
+func (l *limiter) setSize(size int) {
+	if size <= 0 {
+		size = runtime.NumCPU()
+	}
+
+	l.mu.Lock()
+	l.size = size
+	l.next()
+	l.mu.Unlock()
+}
+
+func (l *limiter) runningCount() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.running
+}
+
+// ticket is a place in line for a limiter.
+type ticket struct {
+	l     *limiter
+	ready chan bool
+	scan  bool
+}
+
+// enqueue takes a place in line, so holders are let in the order they
+// arrived in, changed files first.
+func (l *limiter) enqueue(scan bool) *ticket {
+	t := &ticket{l: l, ready: make(chan bool, 1), scan: scan}
+
+	l.mu.Lock()
+	l.queues[t.prio()] = append(l.queues[t.prio()], t.ready)
+	l.next()
+	l.mu.Unlock()
+	return t
+}
+
+func (t *ticket) prio() int {
+	if t.scan {
+		return 1
+	}
+	return 0
+}
+
+// wait returns once the ticket is let in, or with ctx's error if it is
+// cancelled first.
+func (t *ticket) wait(ctx context.Context) error {
+	select {
+	case <-t.ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	t.drop()
+	return ctx.Err()
+}
+
+// drop gives up the ticket's place in line, or its place in the limiter
+// if it was let in already.
+func (t *ticket) drop() {
+	l, prio := t.l, t.prio()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, ch := range l.queues[prio] {
+		if ch == t.ready {
+			l.queues[prio] = append(l.queues[prio][:i], l.queues[prio][i+1:]...)
+			return
+		}
+	}
+
+	// let in already, pass the place on
+	l.running--
+	l.next()
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.running--
+	l.next()
+	l.mu.Unlock()
+}
+
+// next lets in waiting holders while there is room. l.mu must be held.
+func (l *limiter) next() {
+	for l.running < l.size {
+		prio := 0
+		if len(l.queues[0]) == 0 {
+			prio = 1
+		}
+		if len(l.queues[prio]) == 0 {
+			return
+		}
+
+		ready := l.queues[prio][0]
+		l.queues[prio] = l.queues[prio][1:]
+		l.running++
+		ready <- true
+	}
+}
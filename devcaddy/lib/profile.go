@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ServerConfig holds the server settings that can be set in the config
+// instead of on the command line.
+type ServerConfig struct {
+	Port, Host string
+	Proxy      string
+	AssetRoot  string
+}
+
+// A profile is merged into the rest of the config:
+//   - objects are merged key by key
+//   - "plugins", "files" and "watch" entries are matched by name, and a
+//     "watch" entry without a name by "dir:ext". Unmatched entries are added.
+//   - a key prefixed with "+" appends to a list instead of replacing it,
+//     ie. "+files": ["ember/ember.prod.js"]
+//   - any other value replaces the original
+var namedLists = []string{"plugins", "files", "watch"}
+
+// applyProfile merges the named profile in raw into its top level.
+func applyProfile(raw map[string]interface{}, name string) error {
+	profiles, _ := raw["profiles"].(map[string]interface{})
+	profile, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		msg := fmt.Sprintf("profile %q is not defined", name)
+		return ConfigErrors{{Path: "profiles", Message: msg, Help: ERROR_PROFILE_NOT_DEFINED + profileNames(profiles)}}
+	}
+
+	errs := ConfigErrors{}
+	path := "profiles." + name
+
+	for k, v := range profile {
+		if isNamedList(k) {
+			merged, err := mergeNamedList(raw[k], v, k)
+			if err != nil {
+				errs.Append(path, err)
+				continue
+			}
+			raw[k] = merged
+			continue
+		}
+		mergeKey(raw, k, v)
+	}
+	return errs.Err()
+}
+
+func mergeKey(base map[string]interface{}, k string, v interface{}) {
+	if strings.HasPrefix(k, "+") {
+		k = k[1:]
+		list, _ := base[k].([]interface{})
+		if extra, ok := v.([]interface{}); ok {
+			base[k] = append(list, extra...)
+			return
+		}
+	}
+	base[k] = mergeValue(base[k], v)
+}
+
+func mergeValue(base, over interface{}) interface{} {
+	b, ok := base.(map[string]interface{})
+	o, ok2 := over.(map[string]interface{})
+	if !ok || !ok2 {
+		return over
+	}
+
+	for k, v := range o {
+		mergeKey(b, k, v)
+	}
+	return b
+}
+
+func mergeNamedList(base, over interface{}, list string) ([]interface{}, error) {
+	entries, _ := base.([]interface{})
+	overs, ok := over.([]interface{})
+	if !ok {
+		return nil, &ConfigError{Path: list, Message: "expected a list of " + list}
+	}
+
+	errs := ConfigErrors{}
+	index := map[string]int{}
+	for i, e := range entries {
+		if name := entryName(list, e); name != "" {
+			index[name] = i
+		}
+	}
+
+	for i, o := range overs {
+		name := entryName(list, o)
+		if name == "" {
+			msg := "a profile entry needs a name to be matched with"
+			errs.Add(list+"["+strconv.Itoa(i)+"]", msg, ERROR_PROFILE_ENTRY_NAME)
+			continue
+		}
+
+		if j, ok := index[name]; ok {
+			entries[j] = mergeValue(entries[j], o)
+		} else {
+			index[name] = len(entries)
+			entries = append(entries, o)
+		}
+	}
+	return entries, errs.Err()
+}
+
+// entryName is the name an entry of a named list is known by, including
+// plugin names determined from their command or path.
+func entryName(list string, entry interface{}) string {
+	m, ok := entry.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+
+	if name, _ := m["name"].(string); name != "" {
+		return name
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+
+	switch list {
+	case "plugins":
+		pc := PluginConfig{}
+		if json.Unmarshal(b, &pc) != nil || pc.Parse() != nil {
+			return ""
+		}
+		return pc.Name
+	case "watch":
+		wc := WatcherConfig{}
+		if json.Unmarshal(b, &wc) != nil {
+			return ""
+		}
+		return wc.WatcherName()
+	}
+	return ""
+}
+
+func isNamedList(k string) bool {
+	for _, l := range namedLists {
+		if k == l {
+			return true
+		}
+	}
+	return false
+}
+
+func profileNames(profiles map[string]interface{}) string {
+	if len(profiles) == 0 {
+		return "* This config has no profiles.\n"
+	}
+
+	names := []string{}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "* Available profiles: " + strings.Join(names, ", ") + "\n"
+}
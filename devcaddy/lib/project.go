@@ -0,0 +1,499 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/fsnotify.v0"
+)
+
+// Project runs the plugins, watchers and store described by a Config.
+// The config can be replaced while it runs with Reload.
+type Project struct {
+	Config     *Config
+	ConfigPath string
+	Profile    string // requested profile, reused on reload
+	Plugins    *Plugins
+	Watchers   *Watchers
+	Store      *Store
+	Pool       *Pool
+	Cache      *Cache // nil if the config has "noCache"
+
+	log       *Logger
+	out       chan *File
+	tasks     chan func()
+	quit      chan bool
+	configW   *fsnotify.Watcher
+	mu        sync.Mutex
+	cond      *sync.Cond
+	processed int
+	scanning  int
+	closed    bool // set by Close, scans stop waiting for their outputs
+	reloading sync.Mutex
+}
+
+func NewProject(c *Config) (*Project, error) {
+	plugins, err := NewPlugins(c.PluginConfs)
+	if err != nil {
+		return nil, err
+	}
+	c.Plugins = plugins
+
+	p := &Project{
+		Config:     c,
+		ConfigPath: "devcaddy.json",
+		Plugins:    plugins,
+		Store:      NewStore(c),
+		Pool:       NewPool(c.Concurrency),
+		log:        c.Log,
+		out:        make(chan *File),
+		tasks:      make(chan func()),
+		quit:       make(chan bool),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.Pool.Log = c.Log
+	if !c.NoCache {
+		p.Cache = NewCache(filepath.Join(c.Root, CACHE_DIR))
+	}
+	plugins.Each(p.attach)
+	go p.route()
+
+	p.Watchers, err = NewWatchers(c, p.out)
+	if err != nil {
+		plugins.Each(func(pl *Plugin) { pl.Close() })
+		close(p.quit)
+		return nil, err
+	}
+	return p, nil
+}
+
+// attach has pl share the project's pool and cache.
+func (p *Project) attach(pl *Plugin) {
+	pl.pool = p.Pool
+	pl.cache = p.Cache
+}
+
+// Build sends every watched file through its plugins. It returns the
+// number of files processed once they are all in the store, which then
+// sends a single update.
+func (p *Project) Build() int {
+	p.Store.Hold()
+	defer p.Store.Release(p.ConfigPath)
+
+	size := p.scan(p.Watchers.All())
+	if p.Cache != nil {
+		if hits, misses := p.Cache.Counts(); hits > 0 {
+			p.log.PrintC("cache", fmt.Sprintf("reused %d of %d plugin output(s)", hits, hits+misses))
+		}
+	}
+	return size
+}
+
+// scan sends the files of ws through their plugins and waits for the
+// outputs.
+func (p *Project) scan(ws []Watcher) int {
+	p.mu.Lock()
+	p.scanning++
+	start := p.processed
+	p.mu.Unlock()
+
+	size := 0
+	for _, w := range ws {
+		size += w.GetAllFiles()
+	}
+
+	p.mu.Lock()
+	for p.processed < start+size && !p.closed {
+		p.cond.Wait()
+	}
+	p.scanning--
+	p.mu.Unlock()
+	return size
+}
+
+// route moves plugin outputs into the store. It is the only goroutine
+// that changes the store, so other changes are sent to it as tasks.
+func (p *Project) route() {
+	for {
+		select {
+		case f := <-p.out:
+			p.mu.Lock()
+			scanning := p.scanning > 0
+			p.mu.Unlock()
+
+			if f != nil {
+				p.log.processedFile(f, scanning)
+				p.Store.Apply(f)
+			}
+
+			p.mu.Lock()
+			p.processed++
+			p.cond.Broadcast()
+			p.mu.Unlock()
+		case fn := <-p.tasks:
+			fn()
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// Close stops the watchers, the plugins and the config watcher, and
+// returns once the plugin processes have exited. The project can't be
+// used afterwards.
+func (p *Project) Close() {
+	p.reloading.Lock()
+	defer p.reloading.Unlock()
+
+	// the outputs of the closed watchers are dropped, so a scan still
+	// waiting for them would never return
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	if p.configW != nil {
+		p.configW.Close()
+	}
+
+	for _, w := range p.Watchers.All() {
+		p.Watchers.Remove(w.Name())
+	}
+
+	var wg sync.WaitGroup
+	p.Plugins.Each(func(pl *Plugin) {
+		wg.Add(1)
+		go func() {
+			pl.Close()
+			wg.Done()
+		}()
+	})
+	wg.Wait()
+	close(p.quit)
+}
+
+func (p *Project) do(fn func()) {
+	done := make(chan bool)
+	p.tasks <- func() {
+		fn()
+		done <- true
+	}
+	<-done
+}
+
+// ConfigDiff lists the plugins and watchers that need to be started or
+// stopped to move from one config to another. A plugin or watcher that
+// changed is both removed and added.
+type ConfigDiff struct {
+	AddedPlugins    []string
+	RemovedPlugins  []string
+	AddedWatchers   []string
+	RemovedWatchers []string
+}
+
+func (d *ConfigDiff) IsEmpty() bool {
+	return len(d.AddedPlugins)+len(d.RemovedPlugins)+len(d.AddedWatchers)+len(d.RemovedWatchers) == 0
+}
+
+func (d *ConfigDiff) String() string {
+	parts := []string{}
+	add := func(label string, names []string) {
+		if len(names) > 0 {
+			parts = append(parts, label+" "+strings.Join(names, ", "))
+		}
+	}
+	add("started plugins:", d.AddedPlugins)
+	add("stopped plugins:", d.RemovedPlugins)
+	add("started watchers:", d.AddedWatchers)
+	add("stopped watchers:", d.RemovedWatchers)
+
+	if len(parts) == 0 {
+		return "nothing changed"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// DiffConfigs compares two validated configs. Watchers using changed
+// plugins are changed too, and every plugin is when the cache changes.
+func DiffConfigs(old, c *Config) *ConfigDiff {
+	d := &ConfigDiff{}
+	newCache := old.Root != c.Root || old.NoCache != c.NoCache
+
+	oldPlugins := map[string]*PluginConfig{}
+	for _, pc := range old.PluginConfs {
+		oldPlugins[pc.Name] = pc
+	}
+
+	changed := map[string]bool{}
+	newPlugins := map[string]bool{}
+	for _, pc := range c.PluginConfs {
+		newPlugins[pc.Name] = true
+		prev := oldPlugins[pc.Name]
+		if prev == nil || newCache || !reflect.DeepEqual(*prev, *pc) {
+			changed[pc.Name] = true
+		}
+	}
+
+	for _, pc := range c.PluginConfs {
+		if changed[pc.Name] {
+			d.AddedPlugins = append(d.AddedPlugins, pc.Name)
+			if oldPlugins[pc.Name] != nil {
+				d.RemovedPlugins = append(d.RemovedPlugins, pc.Name)
+			}
+		}
+	}
+	for _, pc := range old.PluginConfs {
+		if !newPlugins[pc.Name] {
+			d.RemovedPlugins = append(d.RemovedPlugins, pc.Name)
+		}
+	}
+
+	oldWatchers := map[string]*WatcherConfig{}
+	for _, e := range old.watcherEntries() {
+		oldWatchers[e.conf.WatcherName()] = e.conf
+	}
+
+	newWatchers := map[string]bool{}
+	for _, e := range c.watcherEntries() {
+		name := e.conf.WatcherName()
+		newWatchers[name] = true
+		prev := oldWatchers[name]
+
+		affected := prev == nil || old.Root != c.Root || !reflect.DeepEqual(*prev, *e.conf)
+		for _, pn := range e.conf.PluginNamesUsed() {
+			affected = affected || changed[pn]
+		}
+
+		if affected {
+			d.AddedWatchers = append(d.AddedWatchers, name)
+			if prev != nil {
+				d.RemovedWatchers = append(d.RemovedWatchers, name)
+			}
+		}
+	}
+	for _, e := range old.watcherEntries() {
+		if name := e.conf.WatcherName(); !newWatchers[name] {
+			d.RemovedWatchers = append(d.RemovedWatchers, name)
+		}
+	}
+
+	sort.Strings(d.RemovedPlugins)
+	sort.Strings(d.RemovedWatchers)
+	return d
+}
+
+// Reload moves the project to config c, only restarting the plugins and
+// watchers that changed. The files of restarted watchers are scanned
+// again, and the store sends a single update once they are processed.
+// A changed plugin that fails to start keeps running as it was, and a
+// watcher that fails is left out, so the next reload tries them again.
+func (p *Project) Reload(c *Config) (*ConfigDiff, error) {
+	p.reloading.Lock()
+	defer p.reloading.Unlock()
+
+	old := p.Config
+	d := DiffConfigs(old, c)
+	p.Pool.SetSize(c.Concurrency)
+	if d.IsEmpty() {
+		return d, nil
+	}
+
+	p.Store.Hold()
+	defer p.Store.Release(p.ConfigPath)
+
+	if old.Root != c.Root || old.NoCache != c.NoCache {
+		p.Cache = nil
+		if !c.NoCache {
+			p.Cache = NewCache(filepath.Join(c.Root, CACHE_DIR))
+		}
+	}
+
+	c.Log = p.log
+	errs := ConfigErrors{}
+	started := []*Plugin{}
+	failed := map[string]bool{}
+	for i, pc := range c.PluginConfs {
+		if !contains(d.AddedPlugins, pc.Name) {
+			continue
+		}
+
+		pl, err := newPluginFromConfig(pc)
+		if err != nil {
+			errs.Append("plugins["+strconv.Itoa(i)+"]", err)
+			failed[pc.Name] = true
+			continue
+		}
+		p.attach(pl)
+		started = append(started, pl)
+	}
+	c.PluginConfs = runningPlugins(old, c, failed)
+
+	for _, name := range d.RemovedWatchers {
+		p.Watchers.Remove(name)
+	}
+
+	for _, name := range d.RemovedPlugins {
+		if pl := p.Plugins.Get(name); pl != nil && !failed[name] {
+			p.Plugins.Remove(name)
+			pl.Close()
+		}
+	}
+	for _, pl := range started {
+		p.Plugins.Add(pl)
+	}
+	c.Plugins = p.Plugins
+
+	ws := []Watcher{}
+	failed = map[string]bool{}
+	for _, e := range c.watcherEntries() {
+		if !contains(d.AddedWatchers, e.conf.WatcherName()) {
+			continue
+		}
+
+		w, err := NewWatcher(c.Root, p.out, e.conf, c)
+		if err != nil {
+			errs.Append(e.path, err)
+			failed[e.conf.WatcherName()] = true
+			continue
+		}
+		p.Watchers.Add(w)
+		ws = append(ws, w)
+	}
+	c.Files, c.WatcherConfs = runningWatchers(c, failed)
+
+	p.do(func() {
+		for _, name := range d.RemovedWatchers {
+			p.Store.RemoveWatcher(name)
+		}
+		p.Store.Replace(old.Files, c.Files, c.Root)
+	})
+	p.Config = c
+
+	p.scan(ws)
+	return d, errs.Err()
+}
+
+// runningPlugins are the plugin configs of c, with the old config of the
+// plugins that failed to start in place of the new one.
+func runningPlugins(old, c *Config, failed map[string]bool) []*PluginConfig {
+	prev := map[string]*PluginConfig{}
+	for _, pc := range old.PluginConfs {
+		prev[pc.Name] = pc
+	}
+
+	pcs := []*PluginConfig{}
+	for _, pc := range c.PluginConfs {
+		switch {
+		case !failed[pc.Name]:
+			pcs = append(pcs, pc)
+		case prev[pc.Name] != nil:
+			pcs = append(pcs, prev[pc.Name])
+		}
+	}
+	return pcs
+}
+
+// runningWatchers are the "files" and "watch" entries of c, without the
+// ones that failed to start.
+func runningWatchers(c *Config, failed map[string]bool) ([]*File, []*WatcherConfig) {
+	files := []*File{}
+	for _, f := range c.Files {
+		if !failed[f.watcherConfig().WatcherName()] {
+			files = append(files, f)
+		}
+	}
+
+	wcs := []*WatcherConfig{}
+	for _, wc := range c.WatcherConfs {
+		if !failed[wc.WatcherName()] {
+			wcs = append(wcs, wc)
+		}
+	}
+	return files, wcs
+}
+
+// WatchConfig reloads the project whenever the config file at path is
+// saved. Invalid configs are reported and the running config is kept.
+func (p *Project) WatchConfig(path string) error {
+	p.reloading.Lock()
+	defer p.reloading.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.ConfigPath = path
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// editors often replace the file, so watch its dir instead
+	if err := fsw.Add(filepath.Dir(path)); err != nil {
+		fsw.Close()
+		return err
+	}
+	p.configW = fsw
+
+	go func() {
+		var changed <-chan time.Time
+		for {
+			select {
+			case evt, ok := <-fsw.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(evt.Name) == filepath.Clean(path) && evt.Op != fsnotify.Chmod {
+					changed = time.After(CONFIG_RELOAD_DELAY)
+				}
+			case err, ok := <-fsw.Errors:
+				if !ok {
+					return
+				}
+				p.log.PrintC("error", "config watcher\n"+err.Error())
+			case <-changed:
+				changed = nil
+				p.reloadConfigFile(path)
+			}
+		}
+	}()
+	return nil
+}
+
+// CONFIG_RELOAD_DELAY groups the events of a single save together.
+const CONFIG_RELOAD_DELAY = 50 * time.Millisecond
+
+func (p *Project) reloadConfigFile(path string) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		p.log.PrintC("error", "Problem reading "+path+"\n"+err.Error())
+		return
+	}
+
+	c, err := NewConfigForProfile(b, p.Profile)
+	if err != nil {
+		p.log.PrintC("error", path+" was not reloaded\n"+err.Error())
+		return
+	}
+
+	d, err := p.Reload(c)
+	p.log.PrintC("server", path+" reloaded, "+d.String())
+	if err != nil {
+		p.log.PrintC("error", err.Error())
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const projectCfg = `
+{
+    "root": "../tmp5",
+    "plugins": [
+        { "name": "upper", "command": "echo", "args": "-n {{fileContent}}1" },
+        { "name": "template", "command": "echo", "args": "-n {{fileContent}}2" },
+        { "name": "lint", "command": "echo", "logOnly": true }
+    ],
+    "files": [
+        { "name": "app.js", "dir": "app", "ext": "js", "plugins": ["upper"], "sidePlugins": ["lint"] },
+        { "name": "vendor.js", "dir": "vendor", "files": ["lib.js"] }
+    ],
+    "watch": [
+        { "dir": "app/templates", "ext": "hbs", "plugins": ["template", "upper"] }
+    ]
+}
+`
+
+func TestDiffConfigs(t *testing.T) {
+	Convey("Given two configs", t, func() {
+		old := newTestConfig(t, projectCfg)
+
+		Convey("Identical configs have no differences", func() {
+			d := DiffConfigs(old, newTestConfig(t, projectCfg))
+			So(d.IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("A changed plugin restarts the watchers using it", func() {
+			c := newTestConfig(t, strings.Replace(projectCfg, "{{fileContent}}1", "{{fileContent}}3", 1))
+			d := DiffConfigs(old, c)
+
+			So(d.AddedPlugins, ShouldResemble, []string{"upper"})
+			So(d.RemovedPlugins, ShouldResemble, []string{"upper"})
+			So(d.AddedWatchers, ShouldResemble, []string{"app.js", "app/templates:hbs"})
+			So(d.RemovedWatchers, ShouldResemble, []string{"app.js", "app/templates:hbs"})
+		})
+
+		Convey("Removed and added watchers are found", func() {
+			c := newTestConfig(t, strings.Replace(projectCfg, `"ext": "hbs"`, `"ext": "handlebars"`, 1))
+			d := DiffConfigs(old, c)
+
+			So(len(d.AddedPlugins), ShouldEqual, 0)
+			So(d.AddedWatchers, ShouldResemble, []string{"app/templates:handlebars"})
+			So(d.RemovedWatchers, ShouldResemble, []string{"app/templates:hbs"})
+		})
+	})
+}
+
+func TestProjectClose(t *testing.T) {
+	Convey("Closing a project during a build ends the build", t, func() {
+		dir := "../tmp5"
+		removeTestDir(t, dir)
+		makeTestDir(t, dir+"/app")
+		makeTestFile(t, dir, "app/main.js", "main", 0)
+		defer removeTestDir(t, dir)
+
+		p, err := NewProject(newTestConfig(t, `{
+			"root": "../tmp5",
+			"noCache": true,
+			"plugins": [{ "name": "slow", "command": "sh", "args": "-c 'touch ../tmp5/started; sleep 600' {{fileName}}" }],
+			"files": [{ "name": "app.js", "dir": "app", "ext": "js", "plugins": ["slow"] }]
+		}`))
+		So(err, ShouldBeNil)
+
+		built := make(chan bool)
+		go func() {
+			p.Build()
+			close(built)
+		}()
+		for {
+			if _, err := os.Stat(dir + "/started"); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		p.Close()
+		select {
+		case <-built:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the build did not end")
+		}
+	})
+}
+
+func TestProjectReload(t *testing.T) {
+	Convey("Given a built project", t, func() {
+		dir := "../tmp5"
+		removeTestDir(t, dir)
+		makeTestDir(t, dir+"/app/templates")
+		makeTestDir(t, dir+"/vendor")
+		makeTestFile(t, dir, "app/main.js", "main", 0)
+		makeTestFile(t, dir, "app/templates/index.hbs", "tpl", 0)
+		makeTestFile(t, dir, "vendor/lib.js", "lib", 20)
+		defer removeTestDir(t, dir)
+
+		p, err := NewProject(newTestConfig(t, projectCfg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Build()
+		<-p.Store.DidUpdate
+
+		So(p.Store.Get("../tmp5/app/main.js"), ShouldEqual, "main1")
+		So(p.Store.Get("../tmp5/app/templates/index.hbs"), ShouldEqual, "tpl21")
+		So(p.Store.Get("vendor.js"), ShouldEqual, "lib")
+
+		Convey("Reload restarts changed plugins, rescans their files and sends one update", func() {
+			unchanged := p.Plugins.Get("lint")
+			c := newTestConfig(t, strings.Replace(projectCfg, "{{fileContent}}1", "{{fileContent}}!", 1))
+
+			d, err := p.Reload(c)
+			So(err, ShouldBeNil)
+			So(d.AddedWatchers, ShouldResemble, []string{"app.js", "app/templates:hbs"})
+
+			So(p.Store.Get("../tmp5/app/main.js"), ShouldEqual, "main!")
+			So(p.Store.Get("../tmp5/app/templates/index.hbs"), ShouldEqual, "tpl2!")
+			So(p.Plugins.Get("lint"), ShouldEqual, unchanged)
+
+			f := <-p.Store.DidUpdate
+			So(f.Name, ShouldEqual, "devcaddy.json")
+
+			select {
+			case f := <-p.Store.DidUpdate:
+				So(f.Name, ShouldBeBlank)
+			case <-time.After(20 * time.Millisecond):
+			}
+		})
+
+		Convey("Reload removes the files of removed entries from the store", func() {
+			c := newTestConfig(t, strings.Replace(projectCfg, `"name": "vendor.js"`, `"name": "lib.js"`, 1))
+
+			_, err := p.Reload(c)
+			So(err, ShouldBeNil)
+			So(p.Store.GetFile("vendor.js"), ShouldBeNil)
+			So(p.Store.Get("lib.js"), ShouldEqual, "lib")
+			So(p.Watchers.Get("vendor.js"), ShouldBeNil)
+		})
+
+		Convey("Reload removes the outputs of removed watch entries", func() {
+			c := newTestConfig(t, strings.Replace(projectCfg, `"ext": "hbs"`, `"ext": "handlebars"`, 1))
+
+			_, err := p.Reload(c)
+			So(err, ShouldBeNil)
+			So(p.Store.GetFile("../tmp5/app/templates/index.hbs"), ShouldBeNil)
+			So(p.Store.Get("../tmp5/app/main.js"), ShouldEqual, "main1")
+		})
+
+		Convey("A changed plugin that fails to start keeps running as it was", func() {
+			makeTestFile(t, dir, "bad.js", "throw new Error('nope');", 0)
+			running := p.Plugins.Get("upper")
+			c := newTestConfig(t, strings.Replace(projectCfg, `"command": "echo", "args": "-n {{fileContent}}1"`, `"path": "../tmp5/bad.js"`, 1))
+
+			_, err := p.Reload(c)
+			errs := err.(ConfigErrors)
+			So(len(errs), ShouldEqual, 1)
+			So(errs[0].Path, ShouldEqual, "plugins[0].path")
+			So(errs[0].Help, ShouldEqual, ERROR_PLUGIN_START)
+
+			So(p.Plugins.Get("upper"), ShouldEqual, running)
+			So(p.Config.PluginConfs[0].Command, ShouldEqual, "echo")
+			So(p.Store.Get("../tmp5/app/main.js"), ShouldEqual, "main1")
+			So(DiffConfigs(p.Config, newTestConfig(t, projectCfg)).IsEmpty(), ShouldBeTrue)
+		})
+
+		Convey("Reload replaces the cache and the plugins using it when the cache settings change", func() {
+			So(p.Cache, ShouldNotBeNil)
+			unchanged := p.Plugins.Get("lint")
+			c := newTestConfig(t, strings.Replace(projectCfg, `"root": "../tmp5",`, `"root": "../tmp5", "noCache": true,`, 1))
+
+			d, err := p.Reload(c)
+			So(err, ShouldBeNil)
+			So(d.AddedPlugins, ShouldResemble, []string{"upper", "template", "lint"})
+			So(p.Cache, ShouldBeNil)
+			So(p.Plugins.Get("lint"), ShouldNotEqual, unchanged)
+		})
+	})
+}
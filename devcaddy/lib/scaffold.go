@@ -0,0 +1,282 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// emberVendorFiles are the main files of the bower packages an ember-cli
+// app is built from, in the order they need to be loaded.
+var emberVendorFiles = []struct{ pkg, file string }{
+	{"loader", "loader/loader.js"},
+	{"jquery", "jquery/dist/jquery.js"},
+	{"handlebars", "handlebars/handlebars.js"},
+	{"ember", "ember/ember.js"},
+	{"ember-data", "ember-data/ember-data.js"},
+	{"ember-cli-shims", "ember-cli-shims/app-shims.js"},
+	{"ember-resolver", "ember-resolver/dist/modules/ember-resolver.js"},
+	{"ember-load-initializers", "ember-load-initializers/ember-load-initializers.js"},
+}
+
+var brocImportRegexp = regexp.MustCompile(`app\.import\(\s*['"]([^'"]+)['"]`)
+
+// ProjectInfo is what InspectProject found out about a project.
+type ProjectInfo struct {
+	Name        string
+	EmberCLI    bool
+	BowerDir    string
+	VendorFiles []string
+
+	HasApp, HasIndex       bool
+	HasTemplates, HasStyle bool
+	HasSass, HasJSHint     bool
+
+	// Notes are things the user should check in the generated config.
+	Notes []string
+}
+
+// InspectProject looks at the Brocfile.js, bower.json, package.json and
+// app/ folder of the project in dir.
+func InspectProject(dir string) *ProjectInfo {
+	pi := &ProjectInfo{
+		Name:     filepath.Base(absPath(dir)),
+		BowerDir: "bower_components",
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	pkg := struct {
+		Name            string
+		Dependencies    map[string]string
+		DevDependencies map[string]string
+	}{}
+	if readJSON(filepath.Join(dir, "package.json"), &pkg) {
+		if pkg.Name != "" {
+			pi.Name = pkg.Name
+		}
+		_, pi.EmberCLI = pkg.DevDependencies["ember-cli"]
+	}
+
+	bowerrc := struct{ Directory string }{}
+	if readJSON(filepath.Join(dir, ".bowerrc"), &bowerrc) && bowerrc.Directory != "" {
+		pi.BowerDir = bowerrc.Directory
+	}
+
+	bower := struct{ Dependencies map[string]string }{}
+	readJSON(filepath.Join(dir, "bower.json"), &bower)
+
+	for _, v := range emberVendorFiles {
+		_, ok := bower.Dependencies[v.pkg]
+		if v.pkg == "ember-data" {
+			_, addon := pkg.DevDependencies["ember-cli-ember-data"]
+			ok = ok || addon
+		}
+		if ok {
+			pi.VendorFiles = append(pi.VendorFiles, v.file)
+		}
+	}
+
+	broc, err := ioutil.ReadFile(filepath.Join(dir, "Brocfile.js"))
+	if err == nil {
+		pi.EmberCLI = pi.EmberCLI || bytes.Contains(broc, []byte("ember-cli"))
+		pi.addBrocImports(string(broc))
+	}
+
+	pi.HasApp = exists("app")
+	pi.HasIndex = exists("app/index.html")
+	pi.HasTemplates = exists("app/templates")
+	pi.HasStyle = exists("app/styles")
+	pi.HasSass = exists("app/styles/sass")
+	pi.HasJSHint = exists(".jshintrc")
+
+	if !pi.EmberCLI {
+		pi.Notes = append(pi.Notes, "No ember-cli project was found, only the app/ files were configured.")
+	}
+	return pi
+}
+
+// addBrocImports adds the files of app.import() calls to the vendor files.
+func (pi *ProjectInfo) addBrocImports(broc string) {
+	for _, m := range brocImportRegexp.FindAllStringSubmatch(broc, -1) {
+		rel, err := filepath.Rel(pi.BowerDir, m[1])
+		if err != nil {
+			rel = m[1]
+		}
+
+		if !contains(pi.VendorFiles, rel) {
+			pi.VendorFiles = append(pi.VendorFiles, rel)
+		}
+	}
+
+	if strings.Contains(broc, "app.import({") {
+		pi.Notes = append(pi.Notes, "Brocfile.js has per environment imports, add them to a profile's vendor.js \"files\".")
+	}
+}
+
+type scaffoldPlugin struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	Args    string `json:"args"`
+	LogOnly bool   `json:"logOnly,omitempty"`
+}
+
+type scaffoldFile struct {
+	Name        string   `json:"name"`
+	Dir         string   `json:"dir"`
+	Ext         string   `json:"ext,omitempty"`
+	Files       []string `json:"files,omitempty"`
+	Plugins     []string `json:"plugins,omitempty"`
+	SidePlugins []string `json:"sidePlugins,omitempty"`
+}
+
+type scaffoldWatch struct {
+	Dir     string   `json:"dir"`
+	Ext     string   `json:"ext"`
+	Proxy   string   `json:"proxy,omitempty"`
+	Plugins []string `json:"plugins"`
+}
+
+type scaffoldConfig struct {
+	Profile  string              `json:"profile,omitempty"`
+	Plugins  []scaffoldPlugin    `json:"plugins"`
+	Files    []scaffoldFile      `json:"files"`
+	Watch    []scaffoldWatch     `json:"watch,omitempty"`
+	Profiles map[string]struct{} `json:"profiles,omitempty"`
+}
+
+// Config generates a starter devcaddy.json. Plugins are expected in the
+// project's plugins/ folder, a note is added for those that are missing.
+func (pi *ProjectInfo) Config(dir string) []byte {
+	c := scaffoldConfig{Plugins: []scaffoldPlugin{}, Files: []scaffoldFile{}}
+
+	addPlugin := func(p scaffoldPlugin, script string) {
+		if script != "" {
+			if _, err := os.Stat(filepath.Join(dir, script)); err != nil {
+				pi.Notes = append(pi.Notes, "Plugin "+p.Name+" expects "+script+" to exist.")
+			}
+		}
+		c.Plugins = append(c.Plugins, p)
+	}
+
+	if pi.EmberCLI {
+		c.Profile = "development"
+		c.Profiles = map[string]struct{}{"development": {}, "test": {}, "production": {}}
+	}
+
+	if pi.HasApp {
+		jsPlugins, jsLinters := []string{}, []string{}
+
+		if pi.EmberCLI {
+			addPlugin(scaffoldPlugin{
+				Name:    "es6-transpiler",
+				Command: "node",
+				Args:    "plugins/es6-transpiler.js",
+			}, "plugins/es6-transpiler.js")
+			jsPlugins = append(jsPlugins, "es6-transpiler")
+		}
+
+		if pi.HasJSHint {
+			addPlugin(scaffoldPlugin{
+				Name:    "jshint",
+				Command: "jshint",
+				Args:    "{{fileName}}",
+				LogOnly: true,
+			}, "")
+			jsLinters = append(jsLinters, "jshint")
+		}
+
+		c.Files = append(c.Files, scaffoldFile{
+			Name:        pi.Name + ".js",
+			Dir:         "app",
+			Ext:         "js",
+			Plugins:     jsPlugins,
+			SidePlugins: jsLinters,
+		})
+	}
+
+	if len(pi.VendorFiles) > 0 {
+		c.Files = append(c.Files, scaffoldFile{
+			Name:  "vendor.js",
+			Dir:   pi.BowerDir,
+			Files: pi.VendorFiles,
+		})
+	}
+
+	if pi.HasIndex {
+		index := scaffoldFile{Name: "index.html", Dir: "app", Files: []string{"index.html"}}
+
+		if pi.EmberCLI {
+			addPlugin(scaffoldPlugin{
+				Name:    "ember-index-injector",
+				Command: "node",
+				Args:    "plugins/ember-index-injector.js {{fileName}} {{fileContent}} {{profile}}",
+			}, "plugins/ember-index-injector.js")
+			index.Plugins = []string{"ember-index-injector"}
+		}
+		c.Files = append(c.Files, index)
+	}
+
+	if pi.HasStyle {
+		c.Files = append(c.Files, scaffoldFile{
+			Name:  pi.Name + ".css",
+			Dir:   "app/styles",
+			Files: []string{"app.css"},
+		})
+	}
+
+	if pi.HasTemplates && pi.EmberCLI {
+		addPlugin(scaffoldPlugin{
+			Name:    "ember-template-compiler",
+			Command: "node",
+			Args:    "plugins/ember-template-compiler.js",
+		}, "plugins/ember-template-compiler.js")
+
+		c.Watch = append(c.Watch, scaffoldWatch{
+			Dir:     "app/templates",
+			Ext:     "hbs",
+			Plugins: []string{"ember-template-compiler", "es6-transpiler"},
+		})
+	}
+
+	if pi.HasSass {
+		addPlugin(scaffoldPlugin{
+			Name:    "sass",
+			Command: "node",
+			Args:    "plugins/sass.js app/styles/sass app/styles/app.css",
+			LogOnly: true,
+		}, "plugins/sass.js")
+
+		c.Watch = append(c.Watch, scaffoldWatch{
+			Dir:     "app/styles/sass",
+			Ext:     "scss",
+			Proxy:   "app/styles/sass/app.scss",
+			Plugins: []string{"sass"},
+		})
+	}
+
+	b, _ := json.MarshalIndent(c, "", "    ")
+	return append(b, '\n')
+}
+
+func readJSON(path string, v interface{}) bool {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(b, v) == nil
+}
+
+func absPath(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	return abs
+}
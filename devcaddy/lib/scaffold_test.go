@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInspectProject(t *testing.T) {
+	Convey("Given the caddytest ember-cli project", t, func() {
+		dir := "../../caddytest"
+		pi := InspectProject(dir)
+
+		Convey("It is detected with its bower folder and vendor files", func() {
+			So(pi.Name, ShouldEqual, "caddytest")
+			So(pi.EmberCLI, ShouldBeTrue)
+			So(pi.BowerDir, ShouldEqual, "vendor")
+			So(pi.VendorFiles, ShouldResemble, []string{
+				"loader/loader.js",
+				"jquery/dist/jquery.js",
+				"handlebars/handlebars.js",
+				"ember/ember.js",
+				"ember-data/ember-data.js",
+				"ember-cli-shims/app-shims.js",
+				"ember-resolver/dist/modules/ember-resolver.js",
+				"ember-load-initializers/ember-load-initializers.js",
+			})
+			So(pi.HasIndex && pi.HasTemplates && pi.HasSass && pi.HasJSHint, ShouldBeTrue)
+		})
+
+		Convey("The generated config is valid", func() {
+			b := pi.Config(dir)
+			So(pi.Notes, ShouldBeEmpty)
+
+			raw := map[string]interface{}{}
+			So(json.Unmarshal(b, &raw), ShouldBeNil)
+			So(raw["profile"], ShouldEqual, "development")
+
+			c, err := NewConfig(b)
+			So(err, ShouldBeNil)
+			So(len(c.Files), ShouldEqual, 4)
+			So(c.Files[0].Name, ShouldEqual, "caddytest.js")
+			So(c.Files[0].PluginNames, ShouldResemble, []string{"es6-transpiler"})
+			So(c.Files[0].SidePluginNames, ShouldResemble, []string{"jshint"})
+			So(c.Files[1].Dir, ShouldEqual, "vendor")
+			So(len(c.WatcherConfs), ShouldEqual, 2)
+			So(c.WatcherConfs[1].Proxy, ShouldEqual, "app/styles/sass/app.scss")
+			So(c.PluginConfs[3].Name, ShouldEqual, "ember-template-compiler")
+			So(c.WatcherConfs[0].PluginNames, ShouldResemble, []string{"ember-template-compiler", "es6-transpiler"})
+		})
+	})
+
+	Convey("Given a Brocfile with imports", t, func() {
+		dir, _ := ioutil.TempDir("", "devcaddy")
+		defer os.RemoveAll(dir)
+
+		ioutil.WriteFile(filepath.Join(dir, "package.json"), []byte(`{"name": "app", "devDependencies": {"ember-cli": "0.1.2"}}`), 0600)
+		ioutil.WriteFile(filepath.Join(dir, "bower.json"), []byte(`{"dependencies": {"ember": "1.8.1"}}`), 0600)
+		ioutil.WriteFile(filepath.Join(dir, "Brocfile.js"), []byte(`
+			app.import('bower_components/moment/moment.js');
+			app.import({development: 'x.js', production: 'x.min.js'});
+		`), 0600)
+
+		pi := InspectProject(dir)
+
+		So(pi.VendorFiles, ShouldResemble, []string{"ember/ember.js", "moment/moment.js"})
+		So(len(pi.Notes), ShouldEqual, 1)
+		So(pi.Notes[0], ShouldContainSubstring, "per environment imports")
+
+		Convey("Missing plugin scripts are noted", func() {
+			os.Mkdir(filepath.Join(dir, "app"), 0700)
+			pi := InspectProject(dir)
+			pi.Config(dir)
+
+			So(pi.Notes[len(pi.Notes)-1], ShouldContainSubstring, "plugins/es6-transpiler.js")
+		})
+	})
+}
@@ -1,12 +1,17 @@
 package lib
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"code.google.com/p/go.net/websocket"
 )
@@ -23,37 +28,133 @@ type WSMessage struct {
 
 func NewServer(store *Store) *Server {
 	server := Server{
-		Store:       store,
-		ReloadChans: make(map[chan string]bool),
+		Store: store,
+		subs:  make(map[chan *File]chan bool),
+		quit:  make(chan bool),
 	}
 
 	go server.listenForStoreUpdates()
 	return &server
 }
 
-// args = [port, proxy, assetRoot]
-func StartServer(store *Store, port, prox, assetRoot string) *Server {
-	s := NewServer(store)
+// Listen opens a TCP listener on host:port. If auto is true and the port
+// is busy, the following ports are tried until a free one is found.
+func Listen(host, port string, auto bool) (net.Listener, error) {
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+
+	tries := 1
+	if auto {
+		tries = MAX_PORT_TRIES
+	}
 
-	if prox != "" {
-		u, err := url.Parse(prox)
-		if err != nil {
-			log.Fatal("Error parsing proxy URL", err)
+	for i := 0; ; i++ {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(p+i)))
+		if err == nil || i == tries-1 {
+			return ln, err
 		}
-		proxy := httputil.NewSingleHostReverseProxy(u)
-		s.Proxy = proxy
 	}
+}
+
+const MAX_PORT_TRIES = 100
+
+// SetProxy sends the requests for pages missing from the store to the
+// prox URL.
+func (s *Server) SetProxy(prox string) error {
+	if prox == "" {
+		s.Proxy = nil
+		return nil
+	}
+
+	u, err := url.Parse(prox)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL %q: %s", prox, err)
+	}
+	s.Proxy = httputil.NewSingleHostReverseProxy(u)
+	return nil
+}
+
+// Handler routes the reload websocket, the assets and the html pages.
+func (s *Server) Handler() http.Handler {
+	root := "assets"
+	if s.AssetRoot != "" {
+		root = s.AssetRoot
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/reload/", s.Websocket())
+	mux.HandleFunc("/"+root+"/", s.Assets)
+	mux.HandleFunc("/", s.Html)
+	return mux
+}
+
+// Serve answers requests on ln until Close is called, which is not
+// reported as an error.
+func (s *Server) Serve(ln net.Listener) error {
+	s.PrependIndex = reloadScript(reloadAddr(ln.Addr()))
+
+	s.mu.Lock()
+	select {
+	case <-s.quit:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	s.http = &http.Server{Handler: s.Handler()}
+	s.mu.Unlock()
+
+	s.Log.PrintC("server", "listening on http://"+reloadAddr(ln.Addr()))
+	err := s.http.Serve(ln)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// SHUTDOWN_TIMEOUT is how long the requests in progress have to finish
+// once the server is closed.
+const SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// Close stops accepting requests, tells the browsers connected to the
+// reload websocket that the server is going away and waits for the
+// requests in progress.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	select {
+	case <-s.quit:
+		s.mu.Unlock()
+		return nil
+	default:
+	}
+	close(s.quit)
+	srv := s.http
+	s.mu.Unlock()
 
-	s.AssetRoot = assetRoot
-	s.PrependIndex = reloadScript(port)
+	s.sockets.Wait()
+	if srv == nil {
+		return nil
+	}
 
-	http.Handle("/reload/", s.Websocket())
+	ctx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
 
-	http.HandleFunc("/assets/", s.Assets)
-	http.HandleFunc("/", s.Html)
+// reloadAddr is the address browsers use to reach the server. Wildcard
+// hosts are replaced with localhost.
+func reloadAddr(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
 
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-	return s
+	ip := net.ParseIP(host)
+	if host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
 }
 
 type Server struct {
@@ -61,7 +162,13 @@ type Server struct {
 	Proxy        *httputil.ReverseProxy
 	PrependIndex string
 	AssetRoot    string
-	ReloadChans  map[chan string]bool
+	Log          *Logger
+
+	mu      sync.Mutex
+	http    *http.Server
+	subs    map[chan *File]chan bool
+	sockets sync.WaitGroup
+	quit    chan bool
 }
 
 func (s *Server) Html(w http.ResponseWriter, r *http.Request) {
@@ -102,7 +209,21 @@ func (s *Server) Assets(w http.ResponseWriter, r *http.Request) {
 	}
 
 	name := r.URL.Path[len("/"+root+"/"):]
+
+	// maps are served for the files that have one, unless the store
+	// has a file of that name
+	if filepath.Ext(name) == ".map" && s.Store.GetFile(name) == nil {
+		if m := s.Store.SourceMap(strings.TrimSuffix(name, ".map")); m != "" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, m)
+			return
+		}
+	}
+
 	file := s.Store.Get(name)
+	if s.Store.HasSourceMap(name) {
+		w.Header().Set("SourceMap", filepath.Base(name)+".map")
+	}
 
 	w.Header().Set("Content Type", assetTypes[filepath.Ext(name)])
 	fmt.Fprint(w, file)
@@ -110,26 +231,88 @@ func (s *Server) Assets(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) Websocket() http.Handler {
 	return websocket.Handler(func(ws *websocket.Conn) {
-		reload := make(chan string)
-		s.ReloadChans[reload] = true
+		s.mu.Lock()
+		select {
+		case <-s.quit:
+			s.mu.Unlock()
+			return
+		default:
+		}
+		s.sockets.Add(1)
+		s.mu.Unlock()
+		defer s.sockets.Done()
 
-		name := <-reload
-		msg := WSMessage{Message: "RELOAD", File: name}
+		updates, unsubscribe := s.Subscribe()
+		defer unsubscribe()
 
-		err := websocket.JSON.Send(ws, msg)
-		if err != nil {
-			log.Println("[ws error]", err)
+		var msg WSMessage
+		select {
+		case f := <-updates:
+			msg = WSMessage{Message: "RELOAD", File: f.Name}
+		case <-s.quit:
+			msg = WSMessage{Message: "GOING_AWAY"}
+			ws.SetWriteDeadline(time.Now().Add(time.Second))
 		}
 
-		delete(s.ReloadChans, reload)
+		if err := websocket.JSON.Send(ws, msg); err != nil {
+			s.Log.Println("[ws error]", err)
+		}
 	})
 }
 
+// Subscribe returns a channel receiving the files the store updates,
+// until the returned func is called. Updates wait for every subscriber
+// to receive them.
+func (s *Server) Subscribe() (<-chan *File, func()) {
+	ch := make(chan *File)
+	done := make(chan bool)
+
+	s.mu.Lock()
+	s.subs[ch] = done
+	s.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs, ch)
+			s.mu.Unlock()
+			close(done)
+		})
+	}
+}
+
+// Subscribers is the number of open subscriptions, including reload
+// websockets.
+func (s *Server) Subscribers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subs)
+}
+
 func (s *Server) listenForStoreUpdates() {
 	for {
-		f := <-s.Store.DidUpdate
-		for ch, _ := range s.ReloadChans {
-			ch <- f.Name
+		var f *File
+		select {
+		case f = <-s.Store.DidUpdate:
+		case <-s.quit:
+			return
+		}
+
+		s.mu.Lock()
+		subs := make(map[chan *File]chan bool, len(s.subs))
+		for ch, done := range s.subs {
+			subs[ch] = done
+		}
+		s.mu.Unlock()
+
+		for ch, done := range subs {
+			select {
+			case ch <- f:
+			case <-done:
+			case <-s.quit:
+				return
+			}
 		}
 	}
 }
@@ -1,11 +1,13 @@
 package lib
 
 import (
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
 	"testing"
+	"time"
 
 	"code.google.com/p/go.net/websocket"
 	. "github.com/smartystreets/goconvey/convey"
@@ -21,7 +23,7 @@ func newTestWR(t *testing.T, url string) (*httptest.ResponseRecorder, *http.Requ
 
 func TestHtmls(t *testing.T) {
 	Convey("Given a server with a store", t, func() {
-		store := NewStore(NewConfig([]byte{}))
+		store := NewStore(&Config{})
 		store.Put("index.html", "index!")
 		store.Put("tests.html", "tests!")
 
@@ -82,7 +84,7 @@ func TestHtmls(t *testing.T) {
 
 func TestAssets(t *testing.T) {
 	Convey("Given a server with a store", t, func() {
-		store := NewStore(NewConfig([]byte{}))
+		store := NewStore(&Config{})
 		store.Put("app.js", "app js")
 		store.Put("app.css", "app css")
 
@@ -100,6 +102,23 @@ func TestAssets(t *testing.T) {
 			So(w.Header().Get("Content Type"), ShouldEqual, "text/css")
 		})
 
+		Convey("Assets points to the source maps of files that have one", func() {
+			store.PutFile(&File{Name: "a.js", Content: "var a", Map: `{"version":3,"sources":["a.es6"],"names":[],"mappings":"AAAA"}`})
+
+			w, r := newTestWR(t, "/assets/a.js")
+			s.Assets(w, r)
+			So(w.Header().Get("SourceMap"), ShouldEqual, "a.js.map")
+
+			w, r = newTestWR(t, "/assets/a.js.map")
+			s.Assets(w, r)
+			So(w.Body.String(), ShouldContainSubstring, `"sources":["/a.es6"]`)
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/json")
+
+			w, r = newTestWR(t, "/assets/app.js")
+			s.Assets(w, r)
+			So(w.Header().Get("SourceMap"), ShouldEqual, "")
+		})
+
 		Convey("Asset root can be changed", func() {
 			s.AssetRoot = "static"
 			w, r := newTestWR(t, "/static/app.js")
@@ -113,7 +132,7 @@ func TestAssets(t *testing.T) {
 
 func TestWebsocket(t *testing.T) {
 	Convey("Given a test server", t, func() {
-		store := NewStore(NewConfig([]byte{}))
+		store := NewStore(&Config{})
 		s := NewServer(store)
 		ts := httptest.NewServer(s.Websocket())
 		addr := ts.Listener.Addr().String()
@@ -131,7 +150,7 @@ func TestWebsocket(t *testing.T) {
 				t.Fatal(err)
 			}
 
-			So(len(s.ReloadChans), ShouldEqual, 2)
+			So(s.Subscribers(), ShouldEqual, 2)
 			store.Put("fooz", "bar")
 
 			var msg WSMessage
@@ -149,8 +168,60 @@ func TestWebsocket(t *testing.T) {
 
 			So(msg2.Message, ShouldEqual, "RELOAD")
 			So(msg2.File, ShouldEqual, "fooz")
-			So(len(s.ReloadChans), ShouldEqual, 0)
+			So(s.Subscribers(), ShouldEqual, 0)
 
 		})
+
+		Convey("WS clients are told when the server is going away", func() {
+			defer ts.Close()
+
+			ws, err := websocket.Dial("ws://"+addr, "", "http://localhost/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for s.Subscribers() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			So(s.Close(), ShouldBeNil)
+
+			var msg WSMessage
+			if err = websocket.JSON.Receive(ws, &msg); err != nil {
+				t.Fatal(err)
+			}
+			So(msg.Message, ShouldEqual, "GOING_AWAY")
+		})
+	})
+}
+
+func TestListen(t *testing.T) {
+	Convey("Given a busy port", t, func() {
+		busy, err := Listen("127.0.0.1", "0", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer busy.Close()
+		_, port, _ := net.SplitHostPort(busy.Addr().String())
+
+		Convey("Listen fails if the port is required", func() {
+			_, err := Listen("127.0.0.1", port, false)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Listen moves to the next free port if auto is set", func() {
+			ln, err := Listen("127.0.0.1", port, true)
+			So(err, ShouldBeNil)
+			defer ln.Close()
+
+			So(ln.Addr().String(), ShouldNotEqual, busy.Addr().String())
+		})
+	})
+
+	Convey("Wildcard hosts are reloaded through localhost", t, func() {
+		addr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0:4200")
+		So(reloadAddr(addr), ShouldEqual, "localhost:4200")
+
+		addr, _ = net.ResolveTCPAddr("tcp", "127.0.0.1:4200")
+		So(reloadAddr(addr), ShouldEqual, "127.0.0.1:4200")
 	})
 }
@@ -0,0 +1,522 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// SourceMap is a decoded version 3 source map. Plugins return maps as
+// JSON in File.Map; they are decoded to be composed through a watcher's
+// plugins and joined for merge files.
+type SourceMap struct {
+	File     string
+	Sources  []string
+	Contents []*string // original content of each source, if known
+	Names    []string
+	Lines    [][]Mapping // mappings of each generated line
+}
+
+// Mapping links a generated column to a position in a source.
+type Mapping struct {
+	Column int
+	Source int // -1 if the column maps to no source
+	Line   int
+	Col    int
+	Name   int // -1 if there is no name
+}
+
+type sourceMapJSON struct {
+	Version        int          `json:"version"`
+	File           string       `json:"file,omitempty"`
+	SourceRoot     string       `json:"sourceRoot,omitempty"`
+	Sources        []string     `json:"sources"`
+	SourcesContent []*string    `json:"sourcesContent,omitempty"`
+	Names          []string     `json:"names"`
+	Mappings       string       `json:"mappings"`
+	Sections       []mapSection `json:"sections,omitempty"`
+}
+
+// mapSection is a part of an index map.
+type mapSection struct {
+	Offset struct {
+		Line   int `json:"line"`
+		Column int `json:"column"`
+	} `json:"offset"`
+	Map *sourceMapJSON `json:"map"`
+}
+
+// ParseSourceMap decodes a source map. Index maps are flattened, and the
+// sourceRoot is prepended to the sources.
+func ParseSourceMap(s string) (*SourceMap, error) {
+	raw := &sourceMapJSON{}
+	if err := json.Unmarshal([]byte(s), raw); err != nil {
+		return nil, fmt.Errorf("invalid source map: %s", err)
+	}
+	return raw.decode()
+}
+
+func (raw *sourceMapJSON) decode() (*SourceMap, error) {
+	if raw.Version != 3 {
+		return nil, fmt.Errorf("unsupported source map version %d", raw.Version)
+	}
+
+	m := &SourceMap{File: raw.File}
+	if len(raw.Sections) > 0 {
+		for _, sec := range raw.Sections {
+			if sec.Map == nil {
+				return nil, errors.New("invalid source map: index map sections need a map")
+			}
+			part, err := sec.Map.decode()
+			if err != nil {
+				return nil, err
+			}
+			m.Append(part, sec.Offset.Line, sec.Offset.Column)
+		}
+		return m, nil
+	}
+
+	for i, src := range raw.Sources {
+		if raw.SourceRoot != "" {
+			src = strings.TrimSuffix(raw.SourceRoot, "/") + "/" + src
+		}
+		m.Sources = append(m.Sources, src)
+
+		var content *string
+		if i < len(raw.SourcesContent) {
+			content = raw.SourcesContent[i]
+		}
+		m.Contents = append(m.Contents, content)
+	}
+	m.Names = raw.Names
+
+	lines, err := decodeMappings(raw.Mappings)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		for _, seg := range line {
+			if seg.Source >= len(m.Sources) || seg.Name >= len(m.Names) {
+				return nil, errors.New("invalid source map: mapping out of range")
+			}
+		}
+	}
+	m.Lines = lines
+	return m, nil
+}
+
+// String encodes m as JSON.
+func (m *SourceMap) String() string {
+	raw := sourceMapJSON{
+		Version:  3,
+		File:     m.File,
+		Sources:  m.Sources,
+		Names:    m.Names,
+		Mappings: encodeMappings(m.Lines),
+	}
+	for _, c := range m.Contents {
+		if c != nil {
+			raw.SourcesContent = m.Contents
+			break
+		}
+	}
+	if raw.Sources == nil {
+		raw.Sources = []string{}
+	}
+	if raw.Names == nil {
+		raw.Names = []string{}
+	}
+
+	b, _ := json.Marshal(raw)
+	return string(b)
+}
+
+// SOURCE_MAP_EXTS are the extensions of the merge files given a source
+// map.
+var SOURCE_MAP_EXTS = map[string]bool{".js": true, ".css": true}
+
+// IdentityMap maps each line of content to the same line of source.
+func IdentityMap(source, content string) *SourceMap {
+	m := &SourceMap{Sources: []string{source}, Contents: []*string{&content}}
+	for i := 0; i <= strings.Count(content, "\n"); i++ {
+		m.Lines = append(m.Lines, []Mapping{{Column: 0, Source: 0, Line: i, Col: 0, Name: -1}})
+	}
+	return m
+}
+
+// Append adds the mappings of part, whose first generated line is line
+// of m and is shifted by col columns.
+func (m *SourceMap) Append(part *SourceMap, line, col int) {
+	sources := make([]int, len(part.Sources))
+	for i, src := range part.Sources {
+		sources[i] = m.addSource(src, part.Contents[i])
+	}
+	names := make([]int, len(part.Names))
+	for i, name := range part.Names {
+		names[i] = m.addName(name)
+	}
+
+	for len(m.Lines) < line+len(part.Lines) {
+		m.Lines = append(m.Lines, nil)
+	}
+	for i, segs := range part.Lines {
+		for _, seg := range segs {
+			if i == 0 {
+				seg.Column += col
+			}
+			if seg.Source >= 0 {
+				seg.Source = sources[seg.Source]
+			}
+			if seg.Name >= 0 {
+				seg.Name = names[seg.Name]
+			}
+			m.Lines[line+i] = append(m.Lines[line+i], seg)
+		}
+	}
+}
+
+func (m *SourceMap) addSource(src string, content *string) int {
+	for i, s := range m.Sources {
+		if s == src {
+			if m.Contents[i] == nil {
+				m.Contents[i] = content
+			}
+			return i
+		}
+	}
+	m.Sources = append(m.Sources, src)
+	m.Contents = append(m.Contents, content)
+	return len(m.Sources) - 1
+}
+
+func (m *SourceMap) addName(name string) int {
+	for i, n := range m.Names {
+		if n == name {
+			return i
+		}
+	}
+	m.Names = append(m.Names, name)
+	return len(m.Names) - 1
+}
+
+// find is the mapping of the generated line and column, or nil.
+func (m *SourceMap) find(line, col int) *Mapping {
+	if line < 0 || line >= len(m.Lines) {
+		return nil
+	}
+	var found *Mapping
+	for i, seg := range m.Lines[line] {
+		if seg.Column > col {
+			break
+		}
+		found = &m.Lines[line][i]
+	}
+	return found
+}
+
+// ComposeSourceMaps maps the output of a plugin to the sources of its
+// input, where outer maps the output to the input and inner maps the
+// input to its sources. Positions the inner map doesn't know of are
+// dropped.
+func ComposeSourceMaps(outer, inner *SourceMap) *SourceMap {
+	m := &SourceMap{File: outer.File}
+	m.Lines = make([][]Mapping, len(outer.Lines))
+
+	for i, segs := range outer.Lines {
+		for _, seg := range segs {
+			if seg.Source < 0 {
+				continue
+			}
+			orig := inner.find(seg.Line, seg.Col)
+			if orig == nil || orig.Source < 0 {
+				continue
+			}
+
+			out := Mapping{Column: seg.Column, Source: m.addSource(inner.Sources[orig.Source], inner.Contents[orig.Source]), Line: orig.Line, Col: orig.Col, Name: -1}
+			if orig.Name >= 0 {
+				out.Name = m.addName(inner.Names[orig.Name])
+			} else if seg.Name >= 0 {
+				out.Name = m.addName(outer.Names[seg.Name])
+			}
+			m.Lines[i] = append(m.Lines[i], out)
+		}
+	}
+	return m
+}
+
+// Relative makes the sources under root relative to it, so they are
+// served from the root of the server.
+func (m *SourceMap) Relative(root string) {
+	for i, src := range m.Sources {
+		// a relative root, ie. "../app", comes with sources relative to
+		// the working directory too
+		if root != "" && filepath.IsAbs(src) == filepath.IsAbs(root) {
+			if rel, err := filepath.Rel(root, src); err == nil && !strings.HasPrefix(rel, "..") {
+				src = rel
+			}
+		}
+		if !strings.Contains(src, "://") && !strings.HasPrefix(src, "/") {
+			src = "/" + filepath.ToSlash(src)
+		}
+		m.Sources[i] = src
+	}
+}
+
+// inlineMapRe matches a source map inlined as a data URL in a trailing
+// comment, as left by compilers such as babel or sass.
+var inlineMapRe = regexp.MustCompile(`\n?(?://[#@] ?sourceMappingURL=data:application/json[^,]*;base64,([A-Za-z0-9+/=]+)\s*|/\*[#@] ?sourceMappingURL=data:application/json[^,]*;base64,([A-Za-z0-9+/=]+)\s*\*/\s*)$`)
+
+// takeSourceMap moves the source map a plugin returned into f.Map. It is
+// either inlined at the end of the content or an output named after f
+// with a ".map" extension.
+func takeSourceMap(f *File) {
+	if f == nil || f.Op == ERROR || f.Op == LOG {
+		return
+	}
+
+	if loc := inlineMapRe.FindStringSubmatchIndex(f.Content); loc != nil {
+		start, end := loc[2], loc[3]
+		if start < 0 {
+			start, end = loc[4], loc[5]
+		}
+		enc := f.Content[start:end]
+		if b, err := base64.StdEncoding.DecodeString(enc); err == nil {
+			f.Map = string(b)
+			f.Content = f.Content[:loc[0]]
+		}
+	}
+
+	outputs := []*File{}
+	for _, o := range f.Outputs {
+		if o.Name == f.Name+".map" {
+			f.Map = o.Content
+			continue
+		}
+		outputs = append(outputs, o)
+	}
+	f.Outputs = outputs
+}
+
+// composeMap maps out to the sources of in, the file the plugin ran on.
+// A plugin that returns no map is only trusted to keep in's map if it
+// left the content as is.
+func composeMap(in, out *File) {
+	if in == nil || out == nil || in == out || in.Map == "" || out.Op == ERROR || out.Op == LOG {
+		return
+	}
+	if out.Map == "" {
+		if out.Content == in.Content {
+			out.Map = in.Map
+		}
+		return
+	}
+
+	outer, err := ParseSourceMap(out.Map)
+	if err != nil {
+		return
+	}
+	inner, err := ParseSourceMap(in.Map)
+	if err != nil {
+		return
+	}
+	out.Map = ComposeSourceMaps(outer, inner).String()
+}
+
+// shiftedMap maps in's content moved down line lines and, on its first
+// line, right col columns back to in, at each position in's own map
+// knows of. It is empty if in has no map, as there is nothing to compose
+// it with.
+func shiftedMap(in *File, line, col int) string {
+	if in.Map == "" {
+		return ""
+	}
+	inner, err := ParseSourceMap(in.Map)
+	if err != nil {
+		return ""
+	}
+
+	m := &SourceMap{Sources: []string{in.Name}, Contents: []*string{nil}}
+	m.Lines = make([][]Mapping, line)
+	for i := 0; i <= strings.Count(in.Content, "\n"); i++ {
+		segs := []Mapping{{Column: 0, Source: 0, Line: i, Col: 0, Name: -1}}
+		if i < len(inner.Lines) {
+			for _, seg := range inner.Lines[i] {
+				if seg.Column > 0 {
+					segs = append(segs, Mapping{Column: seg.Column, Source: 0, Line: i, Col: seg.Column, Name: -1})
+				}
+			}
+		}
+		if i == 0 {
+			for j := range segs {
+				segs[j].Column += col
+			}
+		}
+		m.Lines = append(m.Lines, segs)
+	}
+	return m.String()
+}
+
+// addSourceContent puts the content of src, the file the watcher found,
+// in out's map, so browsers can show it without fetching it.
+func addSourceContent(out, src *File) {
+	if out.Map == "" || out.Op == ERROR || out.Op == LOG {
+		return
+	}
+	m, err := ParseSourceMap(out.Map)
+	if err != nil {
+		return
+	}
+
+	for i, s := range m.Sources {
+		if s == src.Name && m.Contents[i] == nil {
+			content := src.Content
+			m.Contents[i] = &content
+			out.Map = m.String()
+			return
+		}
+	}
+}
+
+// concatMaps maps files joined with "\n" to their sources. Files without
+// a map are mapped line for line to themselves.
+func concatMaps(files []*File) *SourceMap {
+	m := &SourceMap{}
+	line := 0
+	for _, f := range files {
+		var part *SourceMap
+		if f.Map != "" {
+			part, _ = ParseSourceMap(f.Map)
+		}
+		if part == nil && f.Content != "" {
+			part = IdentityMap(f.Name, f.Content)
+		}
+		if part != nil {
+			m.Append(part, line, 0)
+		}
+		line += strings.Count(f.Content, "\n") + 1
+	}
+	return m
+}
+
+const vlqChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+func decodeMappings(s string) ([][]Mapping, error) {
+	lines := [][]Mapping{}
+	src, line, col, name := 0, 0, 0, 0
+
+	for _, l := range strings.Split(s, ";") {
+		segs := []Mapping{}
+		column := 0
+		for _, str := range strings.Split(l, ",") {
+			if str == "" {
+				continue
+			}
+			fields, err := decodeVLQ(str)
+			if err != nil {
+				return nil, err
+			}
+
+			column += fields[0]
+			seg := Mapping{Column: column, Source: -1, Name: -1}
+			switch len(fields) {
+			case 1:
+			case 4, 5:
+				src += fields[1]
+				line += fields[2]
+				col += fields[3]
+				seg.Source, seg.Line, seg.Col = src, line, col
+				if len(fields) == 5 {
+					name += fields[4]
+					seg.Name = name
+				}
+			default:
+				return nil, fmt.Errorf("invalid source map: mapping %q has %d fields", str, len(fields))
+			}
+			if seg.Source < 0 || seg.Line < 0 || seg.Col < 0 || seg.Column < 0 {
+				return nil, errors.New("invalid source map: negative position")
+			}
+			segs = append(segs, seg)
+		}
+		lines = append(lines, segs)
+	}
+	return lines, nil
+}
+
+func decodeVLQ(s string) ([]int, error) {
+	fields := []int{}
+	value, shift := 0, uint(0)
+	for _, c := range s {
+		digit := strings.IndexRune(vlqChars, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid source map: bad mapping character %q", c)
+		}
+		value += (digit & 31) << shift
+		if digit&32 != 0 {
+			shift += 5
+			continue
+		}
+		if value&1 != 0 {
+			fields = append(fields, -(value >> 1))
+		} else {
+			fields = append(fields, value>>1)
+		}
+		value, shift = 0, 0
+	}
+	if shift != 0 {
+		return nil, errors.New("invalid source map: truncated mapping")
+	}
+	return fields, nil
+}
+
+func encodeMappings(lines [][]Mapping) string {
+	b := &strings.Builder{}
+	src, line, col, name := 0, 0, 0, 0
+
+	for i, segs := range lines {
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		column := 0
+		for j, seg := range segs {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			encodeVLQ(b, seg.Column-column)
+			column = seg.Column
+			if seg.Source < 0 {
+				continue
+			}
+			encodeVLQ(b, seg.Source-src)
+			encodeVLQ(b, seg.Line-line)
+			encodeVLQ(b, seg.Col-col)
+			src, line, col = seg.Source, seg.Line, seg.Col
+			if seg.Name >= 0 {
+				encodeVLQ(b, seg.Name-name)
+				name = seg.Name
+			}
+		}
+	}
+	return b.String()
+}
+
+func encodeVLQ(b *strings.Builder, n int) {
+	v := n << 1
+	if n < 0 {
+		v = (-n << 1) | 1
+	}
+	for {
+		digit := v & 31
+		v >>= 5
+		if v > 0 {
+			digit |= 32
+		}
+		b.WriteByte(vlqChars[digit])
+		if v == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package lib
+
+import (
+	"encoding/base64"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// one line "var a" compiled from line 2 of a.es6, and a name
+const testMap = `{"version":3,"sources":["a.es6"],"names":["a"],"mappings":"AACA,IAAIA"}`
+
+func TestSourceMaps(t *testing.T) {
+	Convey("Source maps", t, func() {
+		Convey("are decoded and encoded", func() {
+			m, err := ParseSourceMap(testMap)
+			So(err, ShouldBeNil)
+			So(m.Sources, ShouldResemble, []string{"a.es6"})
+			So(m.Lines, ShouldResemble, [][]Mapping{{
+				{Column: 0, Source: 0, Line: 1, Col: 0, Name: -1},
+				{Column: 4, Source: 0, Line: 1, Col: 4, Name: 0},
+			}})
+			So(m.String(), ShouldEqual, testMap)
+		})
+
+		Convey("report invalid maps", func() {
+			_, err := ParseSourceMap(`{"version":2}`)
+			So(err.Error(), ShouldContainSubstring, "version 2")
+
+			_, err = ParseSourceMap(`{"version":3,"sources":[],"mappings":"AACA"}`)
+			So(err.Error(), ShouldContainSubstring, "out of range")
+		})
+
+		Convey("are composed through plugins", func() {
+			// the second plugin indents the first plugin's output
+			inner, _ := ParseSourceMap(testMap)
+			outer := &SourceMap{Sources: []string{"a.js"}, Lines: [][]Mapping{{{Column: 2, Source: 0, Line: 0, Col: 4, Name: -1}}}}
+
+			m := ComposeSourceMaps(outer, inner)
+			So(m.Sources, ShouldResemble, []string{"a.es6"})
+			So(m.Names, ShouldResemble, []string{"a"})
+			So(m.Lines, ShouldResemble, [][]Mapping{{{Column: 2, Source: 0, Line: 1, Col: 4, Name: 0}}})
+		})
+
+		Convey("are joined with the line offsets of merged files", func() {
+			m := concatMaps([]*File{
+				{Name: "/proj/vendor.js", Content: "x\ny"},
+				{Name: "/proj/a.js", Content: "var a", Map: testMap},
+			})
+
+			So(m.Sources, ShouldResemble, []string{"/proj/vendor.js", "a.es6"})
+			So(len(m.Lines), ShouldEqual, 3)
+			So(m.Lines[1], ShouldResemble, []Mapping{{Column: 0, Source: 0, Line: 1, Col: 0, Name: -1}})
+			So(m.Lines[2][1], ShouldResemble, Mapping{Column: 4, Source: 1, Line: 1, Col: 4, Name: 0})
+		})
+
+		Convey("have their sources made relative to the root", func() {
+			m := &SourceMap{Sources: []string{"../caddytest/app/router.js", "a.es6"}}
+			m.Relative("../caddytest")
+			So(m.Sources, ShouldResemble, []string{"/app/router.js", "/a.es6"})
+
+			m = &SourceMap{Sources: []string{"/proj/app/router.js"}}
+			m.Relative("/proj")
+			So(m.Sources, ShouldResemble, []string{"/app/router.js"})
+		})
+
+		Convey("index maps are flattened", func() {
+			m, err := ParseSourceMap(`{"version":3,"sections":[{"offset":{"line":3,"column":0},"map":` + testMap + `}]}`)
+			So(err, ShouldBeNil)
+			So(len(m.Lines), ShouldEqual, 4)
+			So(m.Lines[3][0].Line, ShouldEqual, 1)
+		})
+
+		Convey("are taken from plugin outputs", func() {
+			f := &File{Name: "a.js", Content: "var a", Outputs: []*File{{Name: "a.js.map", Content: testMap}}}
+			takeSourceMap(f)
+			So(f.Map, ShouldEqual, testMap)
+			So(f.Outputs, ShouldBeEmpty)
+
+			inline := "var a\n//# sourceMappingURL=data:application/json;charset=utf-8;base64," + base64.StdEncoding.EncodeToString([]byte(testMap))
+			f = &File{Name: "a.js", Content: inline}
+			takeSourceMap(f)
+			So(f.Map, ShouldEqual, testMap)
+			So(f.Content, ShouldEqual, "var a")
+		})
+
+		Convey("are kept by plugins that leave the content as is", func() {
+			in := &File{Name: "a.js", Content: "var a", Map: testMap}
+			out := &File{Name: "a.js", Content: "var a"}
+			composeMap(in, out)
+			So(out.Map, ShouldEqual, testMap)
+
+			out = &File{Name: "a.js", Content: "var b"}
+			composeMap(in, out)
+			So(out.Map, ShouldEqual, "")
+		})
+	})
+}
@@ -62,3 +62,11 @@ func removeTestFile(t *testing.T, name string) {
 		t.Fatal(err)
 	}
 }
+
+func newTestConfig(t *testing.T, cfg string) *Config {
+	c, err := NewConfig([]byte(cfg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
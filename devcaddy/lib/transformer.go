@@ -0,0 +1,260 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Transformer transforms files in the devcaddy process, without starting
+// a command. It is used by the plugins whose "transformer" is the name it
+// was registered with, and gets their "opts", ie.
+//
+//	{ "name": "banner", "transformer": "wrap", "opts": { "header": "/* {{fileName}} */" } }
+//
+// Transform must not change f, it returns a new file or f as is. A nil
+// file is no output.
+type Transformer interface {
+	Transform(f *File, opts interface{}) (*File, error)
+}
+
+// TransformerFunc lets a func be registered as a Transformer.
+type TransformerFunc func(f *File, opts interface{}) (*File, error)
+
+func (fn TransformerFunc) Transform(f *File, opts interface{}) (*File, error) {
+	return fn(f, opts)
+}
+
+// OptsChecker is implemented by transformers that check their opts when
+// the config is loaded.
+type OptsChecker interface {
+	CheckOpts(opts interface{}) error
+}
+
+var (
+	transformersMu sync.RWMutex
+	transformers   = map[string]Transformer{}
+)
+
+// RegisterTransformer makes t available to plugins under name. It panics
+// if the name is taken, so it is meant to be called from init.
+func RegisterTransformer(name string, t Transformer) {
+	transformersMu.Lock()
+	defer transformersMu.Unlock()
+
+	if t == nil {
+		panic("devcaddy: RegisterTransformer " + name + " is nil")
+	}
+	if transformers[name] != nil {
+		panic("devcaddy: RegisterTransformer called twice for " + name)
+	}
+	transformers[name] = t
+}
+
+// Transformers lists the registered names, sorted.
+func Transformers() []string {
+	transformersMu.RLock()
+	defer transformersMu.RUnlock()
+
+	names := []string{}
+	for name := range transformers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func getTransformer(name string) Transformer {
+	transformersMu.RLock()
+	defer transformersMu.RUnlock()
+	return transformers[name]
+}
+
+// checkTransformer reports an unknown transformer or invalid opts.
+func (cfg *PluginConfig) checkTransformer() error {
+	t := getTransformer(cfg.Transformer)
+	if t == nil {
+		msg := fmt.Sprintf("unknown transformer %q", cfg.Transformer)
+		return &ConfigError{Path: "transformer", Message: msg, Help: ERROR_PLUGIN_TRANSFORMER}
+	}
+
+	if c, ok := t.(OptsChecker); ok {
+		if err := c.CheckOpts(cfg.Opts); err != nil {
+			return &ConfigError{Path: "opts", Message: err.Error(), Help: ERROR_PLUGIN_TRANSFORMER}
+		}
+	}
+	return nil
+}
+
+func NewTransformerPlugin(cfg *PluginConfig) (*Plugin, error) {
+	if err := cfg.Parse(); err != nil {
+		return nil, err
+	}
+	t := getTransformer(cfg.Transformer)
+
+	return NewPlugin(cfg, func(f *File) *File {
+		if f.IsDeleted() {
+			return NewFileWithContent(f.Name, "", f.Op)
+		}
+
+		out, err := t.Transform(f, cfg.Opts)
+		if err != nil {
+			return &File{Name: f.Name, Op: ERROR, PluginName: cfg.Name, Error: err}
+		}
+		if out != nil && out != f {
+			out.PluginName = cfg.Name
+		}
+		return out
+	}), nil
+}
+
+// builtinTransformer is a Transformer with opts decoded into a new opts()
+// value.
+type builtinTransformer struct {
+	opts func() interface{}
+	fn   func(f *File, opts interface{}) (*File, error)
+}
+
+func (b *builtinTransformer) decode(opts interface{}) (interface{}, error) {
+	v := b.opts()
+	if opts == nil || v == nil {
+		return v, nil
+	}
+
+	raw, err := json.Marshal(opts)
+	if err == nil {
+		err = json.Unmarshal(raw, v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid opts: %s", err)
+	}
+	if c, ok := v.(interface{ check() error }); ok {
+		err = c.check()
+	}
+	return v, err
+}
+
+func (b *builtinTransformer) CheckOpts(opts interface{}) error {
+	_, err := b.decode(opts)
+	return err
+}
+
+func (b *builtinTransformer) Transform(f *File, opts interface{}) (*File, error) {
+	v, err := b.decode(opts)
+	if err != nil {
+		return nil, err
+	}
+	return b.fn(f, v)
+}
+
+// transformed is f with new content, and without the outputs and map
+// that belong to f.
+func transformed(f *File, content string) *File {
+	out := *f
+	out.Content = content
+	out.Outputs = nil
+	out.Map = ""
+	return &out
+}
+
+type replaceOpts struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Regexp bool   `json:"regexp"`
+}
+
+func (o *replaceOpts) check() error {
+	if o.From == "" {
+		return errors.New(`replace needs a "from" string`)
+	}
+	if o.Regexp {
+		if _, err := regexp.Compile(o.From); err != nil {
+			return fmt.Errorf("invalid regexp %q: %s", o.From, err)
+		}
+	}
+	return nil
+}
+
+type wrapOpts struct {
+	Header string `json:"header"`
+	Footer string `json:"footer"`
+}
+
+type amdOpts struct {
+	Prefix string `json:"prefix"` // prepended to module names
+	Base   string `json:"base"`   // dir module names are relative to
+}
+
+// moduleName is f's name without its extension, relative to the last dir
+// named Base.
+func (o *amdOpts) moduleName(f *File) string {
+	name := filepath.ToSlash(strings.TrimSuffix(f.Name, filepath.Ext(f.Name)))
+	if o.Base == "" {
+		name = filepath.Base(name)
+	} else if i := strings.LastIndex(name, "/"+strings.Trim(o.Base, "/")+"/"); i >= 0 {
+		name = name[i+len(strings.Trim(o.Base, "/"))+2:]
+	}
+	if o.Prefix != "" {
+		name = strings.TrimSuffix(o.Prefix, "/") + "/" + name
+	}
+	return name
+}
+
+// wrapContent puts header and footer around f's content, keeping its
+// source map.
+func wrapContent(f *File, header, footer string) *File {
+	out := transformed(f, header+f.Content+footer)
+	out.Map = shiftedMap(f, strings.Count(header, "\n"), len(header)-strings.LastIndex(header, "\n")-1)
+	return out
+}
+
+func init() {
+	RegisterTransformer("identity", TransformerFunc(func(f *File, opts interface{}) (*File, error) {
+		return f, nil
+	}))
+
+	RegisterTransformer("replace", &builtinTransformer{
+		opts: func() interface{} { return &replaceOpts{} },
+		fn: func(f *File, v interface{}) (*File, error) {
+			o := v.(*replaceOpts)
+			if o.Regexp {
+				re := regexp.MustCompile(o.From)
+				return transformed(f, re.ReplaceAllString(f.Content, o.To)), nil
+			}
+			return transformed(f, strings.Replace(f.Content, o.From, o.To, -1)), nil
+		},
+	})
+
+	RegisterTransformer("wrap", &builtinTransformer{
+		opts: func() interface{} { return &wrapOpts{} },
+		fn: func(f *File, v interface{}) (*File, error) {
+			o := v.(*wrapOpts)
+			return wrapContent(f, expandFileVars(o.Header, f), expandFileVars(o.Footer, f)), nil
+		},
+	})
+
+	RegisterTransformer("json-minify", &builtinTransformer{
+		opts: func() interface{} { return nil },
+		fn: func(f *File, v interface{}) (*File, error) {
+			b := &bytes.Buffer{}
+			if err := json.Compact(b, []byte(f.Content)); err != nil {
+				return nil, fmt.Errorf("invalid JSON in %s: %s", f.Name, err)
+			}
+			return transformed(f, b.String()), nil
+		},
+	})
+
+	RegisterTransformer("amd-wrap", &builtinTransformer{
+		opts: func() interface{} { return &amdOpts{} },
+		fn: func(f *File, v interface{}) (*File, error) {
+			header := fmt.Sprintf("define(%q, [\"require\", \"exports\", \"module\"], function(require, exports, module) {\n", v.(*amdOpts).moduleName(f))
+			return wrapContent(f, header, "\n});"), nil
+		},
+	})
+}
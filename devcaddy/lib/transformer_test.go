@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func transform(t *testing.T, name string, opts interface{}, f *File) *File {
+	p, err := NewTransformerPlugin(&PluginConfig{Transformer: name, Opts: opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	return p.Run(context.Background(), f)
+}
+
+func TestTransformers(t *testing.T) {
+	Convey("Transformers can be registered by name", t, func() {
+		RegisterTransformer("test-upper", TransformerFunc(func(f *File, opts interface{}) (*File, error) {
+			if f.Content == "fail" {
+				return nil, errors.New("can't upper that")
+			}
+			return transformed(f, strings.ToUpper(f.Content)), nil
+		}))
+		defer func() {
+			transformersMu.Lock()
+			delete(transformers, "test-upper")
+			transformersMu.Unlock()
+		}()
+
+		So(Transformers(), ShouldContain, "test-upper")
+		So(func() { RegisterTransformer("test-upper", TransformerFunc(nil)) }, ShouldPanic)
+
+		plugins, err := NewPlugins([]*PluginConfig{{Transformer: "test-upper"}})
+		So(err, ShouldBeNil)
+		p := plugins.Get("test-upper")
+		defer p.Close()
+
+		out := p.Run(context.Background(), &File{Name: "a.js", Content: "abc", Op: WRITE})
+		So(out.Content, ShouldEqual, "ABC")
+		So(out.PluginName, ShouldEqual, "test-upper")
+
+		out = p.Run(context.Background(), &File{Name: "a.js", Content: "fail", Op: WRITE})
+		So(out.Op, ShouldEqual, ERROR)
+		So(out.Error.Error(), ShouldEqual, "can't upper that")
+	})
+
+	Convey("Unknown transformers and invalid opts are config errors", t, func() {
+		pc := PluginConfig{Transformer: "nope"}
+		err := pc.Parse().(*ConfigError)
+		So(err.Path, ShouldEqual, "transformer")
+		So(err.Help, ShouldEqual, ERROR_PLUGIN_TRANSFORMER)
+
+		pc = PluginConfig{Transformer: "replace", Opts: map[string]interface{}{"to": "b"}}
+		err = pc.Parse().(*ConfigError)
+		So(err.Path, ShouldEqual, "opts")
+		So(err.Message, ShouldContainSubstring, `"from"`)
+	})
+
+	Convey("Given the built in transformers", t, func() {
+		f := &File{Name: "/proj/app/routes/foo.js", Content: "var a = 1;", Op: WRITE}
+
+		Convey("identity returns the file as is", func() {
+			So(transform(t, "identity", nil, f), ShouldEqual, f)
+		})
+
+		Convey("replace replaces strings or regexps", func() {
+			out := transform(t, "replace", map[string]interface{}{"from": "1", "to": "2"}, f)
+			So(out.Content, ShouldEqual, "var a = 2;")
+
+			out = transform(t, "replace", map[string]interface{}{"from": `\d`, "to": "x", "regexp": true}, f)
+			So(out.Content, ShouldEqual, "var a = x;")
+			So(f.Content, ShouldEqual, "var a = 1;")
+		})
+
+		Convey("wrap adds a header and footer", func() {
+			out := transform(t, "wrap", map[string]interface{}{"header": "// {{baseName}}\n", "footer": "\n// end"}, f)
+			So(out.Content, ShouldEqual, "// foo\nvar a = 1;\n// end")
+		})
+
+		Convey("wrap keeps the source map", func() {
+			f.Map = `{"version":3,"sources":["foo.es6"],"names":[],"mappings":"AAAA,IAAI"}`
+			out := transform(t, "wrap", map[string]interface{}{"header": "(function() {\n  "}, f)
+			composeMap(f, out)
+
+			m, _ := ParseSourceMap(out.Map)
+			So(m.Sources, ShouldResemble, []string{"foo.es6"})
+			So(m.Lines[1], ShouldResemble, []Mapping{{Column: 2, Source: 0, Line: 0, Col: 0, Name: -1}, {Column: 6, Source: 0, Line: 0, Col: 4, Name: -1}})
+		})
+
+		Convey("json-minify compacts JSON", func() {
+			out := transform(t, "json-minify", nil, &File{Name: "a.json", Content: "{\n  \"a\": [1, 2]\n}\n", Op: WRITE})
+			So(out.Content, ShouldEqual, `{"a":[1,2]}`)
+
+			out = transform(t, "json-minify", nil, &File{Name: "a.json", Content: "{", Op: WRITE})
+			So(out.Op, ShouldEqual, ERROR)
+		})
+
+		Convey("amd-wrap defines a module named after the file", func() {
+			out := transform(t, "amd-wrap", map[string]interface{}{"prefix": "caddytest", "base": "app"}, f)
+			So(out.Content, ShouldStartWith, `define("caddytest/routes/foo", ["require", "exports", "module"], function(require, exports, module) {`+"\nvar a = 1;")
+			So(out.Content, ShouldEndWith, "\n});")
+
+			out = transform(t, "amd-wrap", nil, f)
+			So(out.Content, ShouldStartWith, `define("foo", `)
+		})
+	})
+}
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"fmt"
+	"io"
 	"log"
 )
 
@@ -17,7 +18,10 @@ var colors = map[string]string{
 	"magenta":  "35",
 	"removed":  "35",
 	"server":   "35",
+	"queue":    "33",
+	"warning":  "33",
 	"cyan":     "36",
+	"cache":    "36",
 	"modified": "36",
 }
 
@@ -25,75 +29,68 @@ func Color(kind, text string) string {
 	return fmt.Sprintf("\033[%sm%s\033[0m", colors[kind], text)
 }
 
-var Plog logging = false
+// Logger prints devcaddy's messages. A nil *Logger discards them, which
+// is what embedders get unless they set Config.Log.
+type Logger struct {
+	l *log.Logger
+}
 
-type logging bool
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{log.New(w, "", log.Ltime)}
+}
 
-func (l *logging) Printf(format string, args ...interface{}) {
-	if Plog {
-		log.Printf(format, args...)
+func (l *Logger) Printf(format string, args ...interface{}) {
+	if l != nil {
+		l.l.Printf(format, args...)
 	}
 }
 
-func (l *logging) Println(kind string, args ...interface{}) {
-	if Plog {
-		log.Println(args...)
+func (l *Logger) Println(args ...interface{}) {
+	if l != nil {
+		l.l.Println(args...)
 	}
 }
 
-func (l *logging) PrintC(kind, text string) {
-	if Plog {
-		log.Println(Color(kind, "["+kind+"] "+text))
+// PrintC prints text prefixed with its kind, in the kind's color.
+func (l *Logger) PrintC(kind, text string) {
+	if l != nil {
+		l.l.Println(Color(kind, "["+kind+"] "+text))
 	}
 }
 
-func LogProcessedFiles(in chan *File, done chan bool, size int) chan *File {
-	out := make(chan *File)
-	go func() {
-		i := 0
-		init := true
-		for {
-			f := <-in
-			if f == nil {
-				continue
-			}
-
-			switch f.Op {
-			case LOG:
-				if f.Content != "" {
-					Plog.PrintC("info", f.Content)
-				}
-			case CREATE:
-				if !init {
-					Plog.PrintC("created", f.Name)
-				}
-			case WRITE:
-				if !init {
-					Plog.PrintC("modified", f.Name)
-				}
-			case REMOVE:
-				Plog.PrintC("removed", f.Name)
-			case RENAME:
-				Plog.PrintC("removed", f.Name)
-			case ERROR:
-				if f.Error != nil {
-					Plog.PrintC("error", f.PluginName+"\n"+f.Error.Error())
-				}
-				if f.Content != "" {
-					Plog.PrintC("error", f.PluginName+"\n"+f.Content)
-				}
-			}
+// processedFile prints what happened to f. Created and modified files
+// are not printed during a scan.
+func (l *Logger) processedFile(f *File, scanning bool) {
+	for _, w := range f.Warnings {
+		l.PrintC("warning", f.Name+"\n"+w)
+	}
+	for _, msg := range f.Logs {
+		l.PrintC("info", msg)
+	}
 
-			if init {
-				i++
-				if i >= size {
-					done <- true
-					init = false
-				}
-			}
-			out <- f
+	switch f.Op {
+	case LOG:
+		if f.Content != "" {
+			l.PrintC("info", f.Content)
 		}
-	}()
-
-	return out
+	case CREATE:
+		if !scanning {
+			l.PrintC("created", f.Name)
+		}
+	case WRITE:
+		if !scanning {
+			l.PrintC("modified", f.Name)
+		}
+	case REMOVE:
+		l.PrintC("removed", f.Name)
+	case RENAME:
+		l.PrintC("removed", f.Name)
+	case ERROR:
+		if f.Error != nil {
+			l.PrintC("error", f.PluginName+"\n"+f.Error.Error())
+		}
+		if f.Content != "" {
+			l.PrintC("error", f.PluginName+"\n"+f.Content)
+		}
+	}
 }
@@ -0,0 +1,247 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Variables available in the config:
+//   {{root}}        the project root
+//   {{profile}}     the selected profile
+//   {{opts.key}}    a value from the plugin's "opts" (plugin args only)
+//   ${env:VAR}      an environment variable
+//
+// Plugin args can also use the variables of the file being processed:
+//   {{fileName}}    path of the file
+//   {{fileContent}} content of the file
+//   {{dir}}         directory of the file
+//   {{ext}}         extension of the file, without the "."
+//   {{baseName}}    name of the file without its directory or extension
+//   {{outputName}}  name of the "files" entry the file belongs to, or of
+//                   its watcher
+//   {{watcher}}     name of the watcher that found the file
+//   {{inputFile}}   temp file holding the content, with "input": "tempfile"
+//   {{outputFile}}  file to write the result to, with "output": "file"
+var (
+	varRegexp    = regexp.MustCompile(`\{\{\s*([\w.-]+)\s*\}\}`)
+	envVarRegexp = regexp.MustCompile(`\$\{env:(\w+)\}`)
+)
+
+var fileVars = map[string]bool{
+	"fileName":    true,
+	"fileContent": true,
+	"dir":         true,
+	"ext":         true,
+	"baseName":    true,
+	"outputName":  true,
+	"watcher":     true,
+	"inputFile":   true,
+	"outputFile":  true,
+}
+
+// configVars resolves the variables known when the config is loaded.
+type configVars struct {
+	root, profile string
+	opts          interface{}
+	getenv        func(string) (string, bool)
+	quote         func(string) string // quotes the values, for a shell
+}
+
+// expand replaces the config variables in s. File variables are left for
+// when a file is processed if allowFileVars is set, otherwise they are
+// errors like any unknown variable.
+func (cv *configVars) expand(s string, allowFileVars bool) (string, error) {
+	errs := []string{}
+
+	s = envVarRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		name := envVarRegexp.FindStringSubmatch(m)[1]
+		v, ok := cv.getenv(name)
+		if !ok {
+			errs = append(errs, "environment variable "+name+" is not set")
+		}
+		return cv.value(v)
+	})
+
+	s = varRegexp.ReplaceAllStringFunc(s, func(m string) string {
+		name := varRegexp.FindStringSubmatch(m)[1]
+
+		switch {
+		case name == "root":
+			return cv.value(cv.root)
+		case name == "profile":
+			return cv.value(cv.profile)
+		case strings.HasPrefix(name, "opts.") && allowFileVars:
+			v, err := lookupOpt(cv.opts, strings.TrimPrefix(name, "opts."))
+			if err != nil {
+				errs = append(errs, err.Error())
+			}
+			return cv.value(v)
+		case fileVars[name] && allowFileVars:
+			return m
+		case fileVars[name]:
+			errs = append(errs, m+" is only available in plugin args")
+			return m
+		}
+
+		errs = append(errs, "unknown variable "+m)
+		return m
+	})
+
+	if len(errs) > 0 {
+		return s, &ConfigError{Message: strings.Join(errs, ", "), Help: ERROR_VARIABLE}
+	}
+	return s, nil
+}
+
+// value is v as it is put in the expanded text.
+func (cv *configVars) value(v string) string {
+	if cv.quote == nil {
+		return v
+	}
+	return cv.quote(v)
+}
+
+// expandArgs replaces the config variables in a plugin's args. The args
+// are split first and joined back quoted, so a value with blanks or
+// quotes stays a single arg. Args run by a shell are expanded in place,
+// with the values quoted, not to break their pipes and redirects.
+func (cv *configVars) expandArgs(args string, shell bool) (string, error) {
+	if shell {
+		cv.quote = shellQuote
+		defer func() { cv.quote = nil }()
+		return cv.expand(args, true)
+	}
+
+	words, err := splitArgs(args)
+	if err != nil {
+		return args, nil // reported by Parse
+	}
+
+	msgs := []string{}
+	for i, word := range words {
+		v, err := cv.expand(word, true)
+		if err != nil {
+			msgs = append(msgs, err.(*ConfigError).Message)
+		}
+		words[i] = shellWord(v)
+	}
+
+	args = strings.Join(words, " ")
+	if len(msgs) > 0 {
+		return args, &ConfigError{Message: strings.Join(msgs, ", "), Help: ERROR_VARIABLE}
+	}
+	return args, nil
+}
+
+// lookupOpt finds a dotted key in opts. Opts given as a JSON string are
+// decoded first.
+func lookupOpt(opts interface{}, key string) (string, error) {
+	if s, ok := opts.(string); ok {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(s), &decoded); err == nil {
+			opts = decoded
+		}
+	}
+
+	v := opts
+	for _, k := range strings.Split(key, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("opts.%s is not defined", key)
+		}
+		if v, ok = m[k]; !ok {
+			return "", fmt.Errorf("opts.%s is not defined", key)
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		return val, nil
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(val)
+		return string(b), err
+	}
+	return fmt.Sprint(v), nil
+}
+
+// expandVars replaces the config variables throughout c.
+func (c *Config) expandVars(getenv func(string) (string, bool)) ConfigErrors {
+	errs := ConfigErrors{}
+	cv := &configVars{root: c.Root, profile: c.Profile, getenv: getenv}
+
+	expand := func(path string, s *string, allowFileVars bool) {
+		v, err := cv.expand(*s, allowFileVars)
+		if err != nil {
+			errs.Append(path, err)
+		}
+		*s = v
+	}
+
+	expandAll := func(path string, list []string) {
+		for i := range list {
+			expand(path+"["+strconv.Itoa(i)+"]", &list[i], false)
+		}
+	}
+
+	for i, pc := range c.PluginConfs {
+		path := "plugins[" + strconv.Itoa(i) + "]"
+		cv.opts = pc.Opts
+		args, err := cv.expandArgs(pc.Args, pc.Shell)
+		errs.Append(path+".args", err)
+		pc.Args = args
+		for j := range pc.ArgList {
+			expand(path+".args["+strconv.Itoa(j)+"]", &pc.ArgList[j], true)
+		}
+		cv.opts = nil
+
+		expand(path+".command", &pc.Command, false)
+		expand(path+".path", &pc.Path, false)
+	}
+
+	for i, f := range c.Files {
+		path := "files[" + strconv.Itoa(i) + "]"
+		expand(path+".name", &f.Name, false)
+		expand(path+".dir", &f.Dir, false)
+		expandAll(path+".files", f.Files)
+	}
+
+	for i, wc := range c.WatcherConfs {
+		path := "watch[" + strconv.Itoa(i) + "]"
+		expand(path+".name", &wc.Name, false)
+		expand(path+".dir", &wc.Dir, false)
+		expand(path+".proxy", &wc.Proxy, false)
+		expandAll(path+".files", wc.Files)
+	}
+
+	return errs
+}
+
+// expandFileVars replaces the file variables in arg with the values for f.
+func expandFileVars(arg string, f *File) string {
+	return varRegexp.ReplaceAllStringFunc(arg, func(m string) string {
+		name := varRegexp.FindStringSubmatch(m)[1]
+		ext := filepath.Ext(f.Name)
+
+		switch name {
+		case "fileName":
+			return f.Name
+		case "fileContent":
+			return f.Content
+		case "dir":
+			return filepath.Dir(f.Name)
+		case "ext":
+			return strings.TrimPrefix(ext, ".")
+		case "baseName":
+			return strings.TrimSuffix(filepath.Base(f.Name), ext)
+		case "outputName":
+			return f.OutputName
+		case "watcher":
+			return f.Watcher
+		}
+		return m
+	})
+}
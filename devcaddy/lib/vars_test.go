@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestConfigVars(t *testing.T) {
+	os.Setenv("DEVCADDY_TEST_VAR", "from-env")
+	defer os.Unsetenv("DEVCADDY_TEST_VAR")
+
+	Convey("Given a config using variables", t, func() {
+		c, err := NewConfigForProfile([]byte(`{
+			"root": "../proj",
+			"profiles": { "production": {} },
+			"plugins": [{
+				"name": "inject",
+				"command": "echo",
+				"args": "{{root}}/x.js {{fileName}} {{profile}} {{opts.env}} {{opts.nested.level}} ${env:DEVCADDY_TEST_VAR}",
+				"opts": { "env": "prod", "nested": { "level": 2 } }
+			}],
+			"files": [{ "name": "app-{{profile}}.js", "dir": "app", "files": ["${env:DEVCADDY_TEST_VAR}.js"] }],
+			"watch": [{ "dir": "styles", "ext": "scss", "proxy": "{{root}}/styles/app.scss" }]
+		}`), "production")
+
+		Convey("Config variables are replaced when it is loaded", func() {
+			So(err, ShouldBeNil)
+			So(c.PluginConfs[0].Args, ShouldEqual, "../proj/x.js {{fileName}} production prod 2 from-env")
+			So(c.Files[0].Name, ShouldEqual, "app-production.js")
+			So(c.Files[0].Files[0], ShouldEqual, "from-env.js")
+			So(c.WatcherConfs[0].Proxy, ShouldEqual, "../proj/styles/app.scss")
+		})
+	})
+
+	Convey("Config variables in args are a single arg", t, func() {
+		c, err := NewConfig([]byte(`{
+			"plugins": [
+				{ "name": "a", "command": "echo", "args": "-n {{opts.quote}} {{opts.blank}} {{fileName}}", "opts": { "quote": "it's here", "blank": "a b" } },
+				{ "name": "b", "command": "printf %s%s", "args": "{{opts.quote}} {{fileContent}} | tr a-z A-Z", "opts": { "quote": "it's $HOME" }, "shell": true }
+			]
+		}`))
+		So(err, ShouldBeNil)
+		So(c.PluginConfs[0].InjectedArgs(&File{Name: "a.js"}), ShouldResemble, []string{"-n", "it's here", "a b", "a.js"})
+
+		p, err := NewCommandPlugin(c.PluginConfs[1])
+		So(err, ShouldBeNil)
+		defer p.Close()
+		So(p.Run(context.Background(), &File{Name: "a.js", Content: "!"}).Content, ShouldEqual, "IT'S $HOME!")
+	})
+
+	Convey("Unknown variables are config errors", t, func() {
+		_, err := NewConfig([]byte(`{
+			"plugins": [{ "name": "a", "command": "echo", "args": "{{nope}} {{opts.missing}} ${env:DEVCADDY_NOT_SET}" }],
+			"files": [{ "name": "{{fileName}}.js" }]
+		}`))
+		errs := err.(ConfigErrors)
+
+		So(len(errs), ShouldEqual, 2)
+		So(errs[0].Path, ShouldEqual, "plugins[0].args")
+		So(errs[0].Message, ShouldContainSubstring, "DEVCADDY_NOT_SET is not set")
+		So(errs[0].Message, ShouldContainSubstring, "unknown variable {{nope}}")
+		So(errs[0].Message, ShouldContainSubstring, "opts.missing is not defined")
+		So(errs[0].Help, ShouldEqual, ERROR_VARIABLE)
+		So(errs[1].Path, ShouldEqual, "files[0].name")
+		So(errs[1].Message, ShouldContainSubstring, "only available in plugin args")
+	})
+}
+
+func TestFileVars(t *testing.T) {
+	Convey("File variables are replaced in plugin args", t, func() {
+		pc := PluginConfig{Command: "echo", Args: "{{dir}} {{baseName}}.{{ext}} {{outputName}} {{watcher}} {{fileContent}}"}
+		f := &File{
+			Name:       "app/templates/index.hbs",
+			Content:    "hi",
+			Watcher:    "app/templates:hbs",
+			OutputName: "app.js",
+		}
+
+		So(pc.InjectedArgs(f), ShouldResemble, []string{
+			"app/templates", "index.hbs", "app.js", "app/templates:hbs", "hi",
+		})
+	})
+}
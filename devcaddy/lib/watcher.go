@@ -1,15 +1,21 @@
 package lib
 
 import (
-	"log"
+	"context"
+	"fmt"
 	"path/filepath"
+	"strconv"
+	"sync"
 
 	"gopkg.in/fsnotify.v0"
 )
 
-func NewWatcher(root string, out chan *File, c *WatcherConfig, config *Config) Watcher {
+func NewWatcher(root string, out chan *File, c *WatcherConfig, config *Config) (Watcher, error) {
 	var wa Watcher
-	_watcher := new_watcher(root, c.Dir, out, c, config)
+	_watcher, err := new_watcher(root, c.Dir, out, c, config)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(c.Files) > 0 {
 		wa = &FileWatcher{
@@ -26,10 +32,14 @@ func NewWatcher(root string, out chan *File, c *WatcherConfig, config *Config) W
 		}
 	}
 
+	if err := wa.addWatchDirs(); err != nil {
+		wa.Close()
+		return nil, &ConfigError{Path: "dir", Message: err.Error(), Help: ERROR_WATCHER_DIR}
+	}
+
 	go _watcher.listen(wa)
 	go _watcher.sendReady()
-	wa.addWatchDirs()
-	return wa
+	return wa, nil
 }
 
 type WatcherConfig struct {
@@ -37,7 +47,27 @@ type WatcherConfig struct {
 	Name, Proxy string
 	GroupAll    bool
 	Files       []string
-	PluginNames []string `json:"plugins"`
+	Output      string `json:"-"` // set for "files" entries
+
+	// Filter skips the files it doesn't match, which are passed on
+	// without running any plugin.
+	Filter
+
+	// PluginNames is a pipeline, each plugin transforming the output of
+	// the one before. SidePluginNames, ie. linters, get the same input as
+	// the pipeline and only log. Graph is used instead of both for
+	// anything else than a chain.
+	PluginNames     []string     `json:"plugins"`
+	SidePluginNames []string     `json:"sidePlugins"`
+	Graph           []*GraphNode `json:"graph"`
+}
+
+// WatcherName is the name given in the config, or "dir:ext".
+func (c *WatcherConfig) WatcherName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Dir + ":" + c.Ext
 }
 
 type Watcher interface {
@@ -45,50 +75,72 @@ type Watcher interface {
 	GetAllFiles() int
 	Ready() chan bool
 	IsWatchingEvent(*Event) bool
-	addWatchDirs()
+	Close() error
+	addWatchDirs() error
 	fsWatcher() *fsnotify.Watcher
 	handleNewDir(*Event)
 	sendFileToPlugin(*Event) int
 }
 
-func new_watcher(root, dir string, out chan *File, c *WatcherConfig, config *Config) watcher {
-	fsw, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
-	}
-
+func new_watcher(root, dir string, out chan *File, c *WatcherConfig, config *Config) (watcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
 	w := watcher{
 		Root:    root,
 		Dir:     dir,
 		Proxy:   c.Proxy,
-		fsw:     fsw,
 		ready:   make(chan bool),
-		Plugins: NewPlugins([]*PluginConfig{}),
+		wName:   c.WatcherName(),
+		output:  c.Output,
+		events:  NewEvents(),
+		log:     config.Log,
+		filter:  c.Filter,
+		out:     out,
+		runs:    newLatestRuns(),
+		ctx:     ctx,
+		cancel:  cancel,
+		sending: &sync.RWMutex{},
 	}
 
-	if len(c.PluginNames) == 0 {
-		c.PluginNames = append(c.PluginNames, "_identity_")
+	if w.output == "" {
+		w.output = w.wName
 	}
 
-	for _, name := range c.PluginNames {
-		p := config.GetPlugin(name)
-		p.SetOutC(out)
-		w.Plugins.Add(p)
+	graph, err := newGraph(c, config)
+	if err != nil {
+		return w, err
+	}
+	w.graph = graph
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		cancel()
+		return w, &ConfigError{Path: "dir", Message: err.Error(), Help: ERROR_WATCHER_DIR}
 	}
+	w.fsw = fsw
 
 	if c.GroupAll {
 		w.store = NewStore(&Config{Root: config.Root})
 	}
-	return w
+	return w, nil
 }
 
 type watcher struct {
 	Root       string
 	Dir, Proxy string
+	wName      string
+	output     string
 	ready      chan bool
 	fsw        *fsnotify.Watcher
 	store      *Store
-	Plugins    *Plugins
+	events     *Events
+	log        *Logger
+	filter     Filter
+	graph      []*graphNode
+	out        chan *File
+	runs       *latestRuns
+	ctx        context.Context
+	cancel     func()
+	sending    *sync.RWMutex // held by sends, Close waits for them
 }
 
 func (w *watcher) Ready() chan bool {
@@ -99,11 +151,17 @@ func (w *watcher) fsWatcher() *fsnotify.Watcher {
 	return w.fsw
 }
 
-func (w *watcher) addWatchDir(path string) {
-	err := w.fsw.Add(path)
-	if err != nil {
-		log.Fatal(err)
-	}
+// Close stops watching the file system. The outputs of files still being
+// processed are dropped, and none is sent once it returns.
+func (w *watcher) Close() error {
+	w.cancel()
+	w.sending.Lock()
+	w.sending.Unlock()
+	return w.fsw.Close()
+}
+
+func (w *watcher) addWatchDir(path string) error {
+	return w.fsw.Add(path)
 }
 
 func (w *watcher) sendReady() {
@@ -113,10 +171,13 @@ func (w *watcher) sendReady() {
 func (w *watcher) listen(wa Watcher) {
 	for {
 		select {
-		case evt := <-w.fsWatcher().Events:
+		case evt, ok := <-w.fsWatcher().Events:
+			if !ok {
+				return
+			}
 			e := NewEvent(evt, wa)
 
-			if e.Ignore() {
+			if e.Ignore(w.events) {
 				continue
 			}
 
@@ -130,7 +191,10 @@ func (w *watcher) listen(wa Watcher) {
 
 			w.sendFileToPlugin(e)
 
-		case err := <-w.fsWatcher().Errors:
+		case err, ok := <-w.fsWatcher().Errors:
+			if !ok {
+				return
+			}
 			w.sendFileToPlugin(NewPseudoEvent("watcher error", ERROR, err))
 		}
 	}
@@ -149,33 +213,196 @@ func (w *watcher) sendFileToPlugin(e *Event) int {
 		f = NewFile(e)
 		f.Content = w.store.GetAllContents()
 	}
+	f.Watcher = w.wName
+	f.OutputName = w.output
+	f.NoCache = w.Proxy != "" || w.store != nil
 
-	return w.Plugins.Each(func(p *Plugin) {
-		p.InC <- f
-	})
+	return w.runGraph(f)
 }
 
-func NewWatchers(c *Config, out chan *File) *Watchers {
+// runGraph sends f through the graph's nodes, each once the nodes it
+// reads from are done, and returns the number of outputs. The outputs are
+// sent together, unless a newer file with the same name cancelled the
+// run. Side nodes only log.
+func (w *watcher) runGraph(f *File) int {
+	if len(w.graph) == 0 || (f.Op != ERROR && !w.filter.Matches(w.Root, f.Name)) {
+		f.Source = f.Name
+		w.send(f)
+		return 1
+	}
+
+	ctx, r := w.runs.start(w.ctx, f.Name)
+	outs := make([]*File, len(w.graph))
+	done := make([]chan bool, len(w.graph))
+	ins := make([]*File, len(w.graph))
+	tickets := make([]*ticket, len(w.graph))
+
+	for i, n := range w.graph {
+		done[i] = make(chan bool)
+
+		// nodes reading the input take their place in line now, so
+		// files are run in the order they came in
+		if len(n.from) == 1 && n.from[0] < 0 {
+			ins[i] = mergeInputs([]*File{f})
+			tickets[i] = n.plugin.reserve(ins[i])
+		}
+	}
+
+	for i, n := range w.graph {
+		go func(i int, n *graphNode) {
+			defer close(done[i])
+
+			in := ins[i]
+			if tickets[i] == nil {
+				from := []*File{}
+				for _, j := range n.from {
+					if j < 0 {
+						from = append(from, f)
+						continue
+					}
+					<-done[j]
+					from = append(from, outs[j])
+				}
+				in = mergeInputs(from)
+			}
+
+			var out *File
+			if tickets[i] != nil {
+				out = n.plugin.runTicket(ctx, tickets[i], in)
+			} else {
+				out = n.plugin.Run(ctx, in)
+			}
+
+			if n.side && out == in && out != nil && out.Op != ERROR {
+				// skipped by the plugin's filter, nothing to log
+				out = nil
+			} else if n.side && out != nil && out.Op != ERROR {
+				out.Op = LOG
+			} else {
+				composeMap(in, out)
+				addMessages(in, out)
+				out = withOutputs(in, out)
+			}
+			outs[i] = out
+		}(i, n)
+	}
+
+	size := 0
+	for _, n := range w.graph {
+		if n.sink {
+			size++
+		}
+	}
+
+	go func() {
+		sent := map[*File]bool{}
+		results := []*File{}
+		for i, n := range w.graph {
+			if !n.sink {
+				continue
+			}
+			<-done[i]
+
+			// an error passed on to several outputs is sent once
+			out := outs[i]
+			if sent[out] {
+				out = nil
+			}
+			sent[out] = true
+			if out != nil {
+				out.Source = f.Name
+				out.Watcher = f.Watcher
+				addSourceContent(out, f)
+			}
+			results = append(results, out)
+		}
+		w.runs.finish(f.Name, r, w.send, results...)
+	}()
+	return size
+}
+
+// send passes f on, unless the watcher is closed first.
+func (w *watcher) send(f *File) {
+	w.sending.RLock()
+	defer w.sending.RUnlock()
+	if w.ctx.Err() != nil {
+		return
+	}
+
+	select {
+	case w.out <- f:
+	case <-w.ctx.Done():
+	}
+}
+
+// NewWatchers creates a watcher for every "files" and "watch" entry.
+// Entries that can't be watched are reported under their config path, and
+// the others are closed.
+func NewWatchers(c *Config, out chan *File) (*Watchers, error) {
 	content := map[string]Watcher{}
+	errs := ConfigErrors{}
 
-	for _, f := range c.Files {
-		wc := &WatcherConfig{
-			Name:        f.Name,
-			Dir:         f.Dir,
-			Ext:         f.Ext,
-			Files:       f.Files,
-			PluginNames: f.PluginNames,
+	add := func(path string, wc *WatcherConfig) {
+		if content[wc.WatcherName()] != nil {
+			msg := fmt.Sprintf("watcher %q is defined more than once", wc.WatcherName())
+			errs.Add(path, msg, ERROR_WATCHER_DUPLICATE)
+			return
+		}
+
+		w, err := NewWatcher(c.Root, out, wc, c)
+		if err != nil {
+			errs.Append(path, err)
+			return
 		}
-		w := NewWatcher(c.Root, out, wc, c)
 		content[w.Name()] = w
 	}
 
-	for _, wc := range c.WatcherConfs {
-		w := NewWatcher(c.Root, out, wc, c)
-		content[w.Name()] = w
+	for _, e := range c.watcherEntries() {
+		add(e.path, e.conf)
+	}
+
+	if err := errs.Err(); err != nil {
+		for _, w := range content {
+			w.Close()
+		}
+		return nil, err
+	}
+	return &Watchers{content}, nil
+}
+
+// watcherConfig is the WatcherConfig of a "files" entry.
+func (f *File) watcherConfig() *WatcherConfig {
+	return &WatcherConfig{
+		Name:            f.Name,
+		Dir:             f.Dir,
+		Ext:             f.Ext,
+		Files:           f.Files,
+		PluginNames:     f.PluginNames,
+		SidePluginNames: f.SidePluginNames,
+		Graph:           f.Graph,
+		Output:          f.Name,
+		Filter:          f.Filter,
+	}
+}
+
+type watcherEntry struct {
+	path string
+	conf *WatcherConfig
+}
+
+// watcherEntries lists a WatcherConfig for each "files" and "watch" entry
+// along with its config path.
+func (c *Config) watcherEntries() []watcherEntry {
+	entries := []watcherEntry{}
+
+	for i, f := range c.Files {
+		entries = append(entries, watcherEntry{"files[" + strconv.Itoa(i) + "]", f.watcherConfig()})
 	}
 
-	return &Watchers{content}
+	for i, wc := range c.WatcherConfs {
+		entries = append(entries, watcherEntry{"watch[" + strconv.Itoa(i) + "]", wc})
+	}
+	return entries
 }
 
 type Watchers struct {
@@ -187,6 +414,26 @@ func (ws *Watchers) Get(name string) Watcher {
 	return w
 }
 
+func (ws *Watchers) Add(w Watcher) {
+	ws.content[w.Name()] = w
+}
+
+// Remove closes the named watcher and stops tracking it.
+func (ws *Watchers) Remove(name string) {
+	if w := ws.content[name]; w != nil {
+		w.Close()
+		delete(ws.content, name)
+	}
+}
+
+func (ws *Watchers) All() []Watcher {
+	all := []Watcher{}
+	for _, w := range ws.content {
+		all = append(all, w)
+	}
+	return all
+}
+
 func (ws *Watchers) GetInitialFiles() int {
 	size := 0
 	for _, w := range ws.content {
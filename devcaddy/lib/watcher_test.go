@@ -29,7 +29,7 @@ func TestFileWatcher(t *testing.T) {
 
 			config := Config{Plugins: &Plugins{}}
 			out := make(chan *File)
-			w := NewWatcher(dir, out, &c, &config)
+			w, _ := NewWatcher(dir, out, &c, &config)
 
 			Convey("GetAllFiles passes the correct files unmodified if no plugin given", func() {
 				defer removeTestDir(t, dir)
@@ -84,7 +84,7 @@ func TestFileWatcher(t *testing.T) {
 			})
 			config := Config{Plugins: &Plugins{content: map[string]*Plugin{"zzz": p}}}
 			out := make(chan *File)
-			w := NewWatcher(dir, out, &c, &config)
+			w, _ := NewWatcher(dir, out, &c, &config)
 
 			<-w.Ready()
 			updateTestFile(t, "../tmp1/foo/index.js", "s")
@@ -113,7 +113,7 @@ func TestDirWatcher(t *testing.T) {
 			PluginNames: []string{"transpile-js"},
 		}
 
-		p := NewCommandPlugin(&PluginConfig{
+		p, _ := NewCommandPlugin(&PluginConfig{
 			Name:    "transpile-js",
 			Command: "echo",
 			Args:    "-n",
@@ -121,7 +121,7 @@ func TestDirWatcher(t *testing.T) {
 
 		config := Config{Plugins: &Plugins{content: map[string]*Plugin{"transpile-js": p}}}
 		out := make(chan *File)
-		w := NewWatcher("", out, &c, &config)
+		w, _ := NewWatcher("", out, &c, &config)
 
 		Convey("GetAllFiles passes the correct files modified by Plugins", func() {
 			defer removeTestDir(t, dir)
@@ -251,7 +251,7 @@ func TestGroupAllWatcher(t *testing.T) {
 			PluginNames: []string{"sass"},
 		}
 
-		p := NewCommandPlugin(&PluginConfig{
+		p, _ := NewCommandPlugin(&PluginConfig{
 			Name:    "sass",
 			Command: "echo",
 			Args:    "-n {{fileContent}}",
@@ -259,7 +259,7 @@ func TestGroupAllWatcher(t *testing.T) {
 
 		config := Config{Plugins: &Plugins{content: map[string]*Plugin{"sass": p}}}
 		out := make(chan *File)
-		w := NewWatcher("", out, &c, &config)
+		w, _ := NewWatcher("", out, &c, &config)
 
 		Convey("GetAllFiles passes to the plugins the content of all of the files being watched that have already been processed", func() {
 			defer removeTestDir(t, dir)
@@ -333,7 +333,7 @@ func TestProxyWatcher(t *testing.T) {
 			PluginNames: []string{"sass"},
 		}
 
-		p := NewCommandPlugin(&PluginConfig{
+		p, _ := NewCommandPlugin(&PluginConfig{
 			Name:    "sass",
 			Command: "echo",
 			Args:    "-n {{fileContent}}",
@@ -341,7 +341,7 @@ func TestProxyWatcher(t *testing.T) {
 
 		config := Config{Plugins: &Plugins{content: map[string]*Plugin{"sass": p}}}
 		out := make(chan *File)
-		w := NewWatcher(dir, out, &c, &config)
+		w, _ := NewWatcher(dir, out, &c, &config)
 
 		Convey("GetAllFiles only passes proxy once", func() {
 			defer removeTestDir(t, dir)
@@ -385,7 +385,7 @@ func TestProxyWatcher(t *testing.T) {
 
 func TestNewWatchers(t *testing.T) {
 	Convey("Given a Config", t, func() {
-		c := NewConfig([]byte(`
+		c := newTestConfig(t, `
     		{
     		    "root": "../mockapp",
     		    "watch": [
@@ -401,10 +401,10 @@ func TestNewWatchers(t *testing.T) {
 		            "ext": "js"
 		        }
 		     ]
-	    }`))
+	    }`)
 
 		out := make(chan *File)
-		ws := NewWatchers(c, out)
+		ws, _ := NewWatchers(c, out)
 
 		Convey("It creates watchers from the 'watch' setting", func() {
 			w := ws.Get("app/templates:hbs")
@@ -416,5 +416,197 @@ func TestNewWatchers(t *testing.T) {
 			So(w.Name(), ShouldEqual, "app.js")
 		})
 
+		Convey("Duplicate watcher names are reported instead of overwritten", func() {
+			c.WatcherConfs = append(c.WatcherConfs, &WatcherConfig{Name: "app.js", Dir: "app"})
+			_, err := NewWatchers(c, out)
+			errs := err.(ConfigErrors)
+
+			So(len(errs), ShouldEqual, 1)
+			So(errs[0].Path, ShouldEqual, "watch[1]")
+			So(errs[0].Help, ShouldEqual, ERROR_WATCHER_DUPLICATE)
+		})
+
+		Convey("Dirs that can't be watched are reported under their path", func() {
+			c.Files = append(c.Files, &File{Name: "missing.js", FileConfig: FileConfig{Dir: "missing", Files: []string{"a/b.js"}}})
+			c.WatcherConfs = append(c.WatcherConfs, &WatcherConfig{Dir: "missing", Ext: "js"})
+			_, err := NewWatchers(c, out)
+			errs := err.(ConfigErrors)
+
+			So(len(errs), ShouldEqual, 2)
+			So(errs[0].Path, ShouldEqual, "files[1].dir")
+			So(errs[0].Help, ShouldEqual, ERROR_WATCHER_DIR)
+			So(errs[1].Path, ShouldEqual, "watch[1].dir")
+		})
+
+	})
+}
+
+func TestWatcherPipeline(t *testing.T) {
+	Convey("Given a watcher with a pipeline and a side plugin", t, func() {
+		dir := "../tmp6"
+		removeTestDir(t, dir)
+		makeTestDir(t, dir)
+		makeTestFile(t, dir, "a.js", "a", 0)
+		defer removeTestDir(t, dir)
+
+		appender := func(name, s string) *Plugin {
+			return NewPlugin(&PluginConfig{Name: name}, func(f *File) *File {
+				return &File{Name: f.Name, Content: f.Content + s, Op: f.Op}
+			})
+		}
+		lint := NewPlugin(&PluginConfig{Name: "lint"}, func(f *File) *File {
+			return &File{Name: f.Name, Content: "linted " + f.Content, Op: f.Op}
+		})
+		config := Config{Plugins: &Plugins{content: map[string]*Plugin{
+			"first":  appender("first", "1"),
+			"second": appender("second", "2"),
+			"lint":   lint,
+		}}}
+
+		out := make(chan *File)
+		w, _ := NewWatcher(dir, out, &WatcherConfig{
+			Dir:             dir,
+			Files:           []string{"a.js"},
+			PluginNames:     []string{"second", "first"},
+			SidePluginNames: []string{"lint"},
+		}, &config)
+		defer w.Close()
+
+		Convey("The stages run in order and the side plugin only logs the input", func() {
+			So(w.GetAllFiles(), ShouldEqual, 2)
+
+			files := map[FileOp]*File{}
+			for i := 0; i < 2; i++ {
+				f := <-out
+				files[f.Op] = f
+			}
+
+			So(files[CREATE].Content, ShouldEqual, "a21")
+			So(files[LOG].Content, ShouldEqual, "linted a")
+		})
+
+		Convey("A plugin without output stops the pipeline", func() {
+			config.Plugins.Add(NewPlugin(&PluginConfig{Name: "second", NoOutput: true}, nil))
+			w, _ := NewWatcher(dir, out, &WatcherConfig{
+				Name:        "none",
+				Dir:         dir,
+				Files:       []string{"a.js"},
+				PluginNames: []string{"second", "first"},
+			}, &config)
+			defer w.Close()
+
+			So(w.GetAllFiles(), ShouldEqual, 1)
+			So(<-out, ShouldBeNil)
+		})
+
+		Convey("Files skipped by a plugin's filter pass through it", func() {
+			skip := Filter{Exclude: []string{"*.js"}}
+			config.Plugins.Add(NewPlugin(&PluginConfig{Name: "second", Filter: skip}, nil))
+			config.Plugins.Add(NewPlugin(&PluginConfig{Name: "lint", Filter: skip}, nil))
+			w, _ := NewWatcher(dir, out, &WatcherConfig{
+				Name:            "filtered",
+				Dir:             dir,
+				Files:           []string{"a.js"},
+				PluginNames:     []string{"second", "first"},
+				SidePluginNames: []string{"lint"},
+			}, &config)
+			defer w.Close()
+
+			So(w.GetAllFiles(), ShouldEqual, 2)
+			outs := []*File{<-out, <-out}
+			if outs[0] == nil {
+				outs[0], outs[1] = outs[1], outs[0]
+			}
+			So(outs[0].Content, ShouldEqual, "a1")
+			So(outs[1], ShouldBeNil)
+		})
+
+		Convey("Files skipped by the watcher's filter are sent as they are", func() {
+			w, _ := NewWatcher(dir, out, &WatcherConfig{
+				Name:        "skipped",
+				Dir:         dir,
+				Files:       []string{"a.js"},
+				PluginNames: []string{"second", "first"},
+				Filter:      Filter{Include: []string{"lib/**"}},
+			}, &config)
+			defer w.Close()
+
+			// sent right away, like the files of a watcher without plugins
+			got := make(chan *File, 1)
+			go func() { got <- <-out }()
+
+			So(w.GetAllFiles(), ShouldEqual, 1)
+			So((<-got).Content, ShouldEqual, "a")
+		})
+	})
+}
+
+func TestWatcherGraph(t *testing.T) {
+	Convey("Given a watcher with a graph", t, func() {
+		dir := "../tmp7"
+		removeTestDir(t, dir)
+		makeTestDir(t, dir)
+		makeTestFile(t, dir, "a.js", "a", 0)
+		defer removeTestDir(t, dir)
+
+		appender := func(name, s string) *Plugin {
+			return NewPlugin(&PluginConfig{Name: name}, func(f *File) *File {
+				return &File{Name: f.Name, Content: f.Content + s, Op: f.Op}
+			})
+		}
+		config := Config{Plugins: &Plugins{content: map[string]*Plugin{
+			"one":   appender("one", "1"),
+			"two":   appender("two", "2"),
+			"three": appender("three", "3"),
+		}}}
+
+		out := make(chan *File)
+		w, err := NewWatcher(dir, out, &WatcherConfig{
+			Dir:   dir,
+			Files: []string{"a.js"},
+			Graph: []*GraphNode{
+				{Name: "merged", Plugin: "three", From: []string{"left", "right"}},
+				{Name: "left", Plugin: "one"},
+				{Name: "right", Plugin: "two"},
+				{Name: "raw", Plugin: "three", From: []string{"input"}},
+			},
+		}, &config)
+		So(err, ShouldBeNil)
+		defer w.Close()
+
+		Convey("The input fans out and the branches merge, each sink being an output", func() {
+			So(w.GetAllFiles(), ShouldEqual, 2)
+
+			contents := []string{(<-out).Content, (<-out).Content}
+			So(contents, ShouldContain, "a1\na23")
+			So(contents, ShouldContain, "a3")
+		})
+
+		Convey("Each watcher has its own wiring for a shared plugin", func() {
+			out2 := make(chan *File)
+			w2, _ := NewWatcher(dir, out2, &WatcherConfig{
+				Name:        "other",
+				Dir:         dir,
+				Files:       []string{"a.js"},
+				PluginNames: []string{"one", "two"},
+			}, &config)
+			defer w2.Close()
+
+			So(w2.GetAllFiles(), ShouldEqual, 1)
+			So((<-out2).Content, ShouldEqual, "a12")
+		})
+
+		Convey("A cycle can't be started", func() {
+			_, err := NewWatcher(dir, out, &WatcherConfig{
+				Name:  "cycle",
+				Dir:   dir,
+				Files: []string{"a.js"},
+				Graph: []*GraphNode{
+					{Name: "x", Plugin: "one", From: []string{"y"}},
+					{Name: "y", Plugin: "two", From: []string{"x"}},
+				},
+			}, &config)
+			So(err, ShouldNotBeNil)
+		})
 	})
 }
@@ -35,7 +35,7 @@ func (w *DirWatcher) GetAllFiles() int {
 		}
 
 		if !info.IsDir() && strings.HasSuffix(path, w.Ext) {
-			size += w.sendFileToPlugin(NewPseudoEvent(path, CREATE))
+			size += w.sendFileToPlugin(NewScanEvent(path))
 
 			if w.Proxy != "" {
 				skip = true
@@ -50,23 +50,25 @@ func (w *DirWatcher) IsWatchingEvent(evt *Event) bool {
 	return strings.HasPrefix(evt.Name(), w.fullPath()) && strings.HasSuffix(evt.Name(), w.Ext)
 }
 
-func (w *DirWatcher) addWatchDirs() {
-	Plog.PrintC("watching", "*."+w.Ext+": "+w.Dir)
+func (w *DirWatcher) addWatchDirs() error {
+	w.log.PrintC("watching", "*."+w.Ext+": "+w.Dir)
 
-	filepath.Walk(w.fullPath(), func(path string, info os.FileInfo, err error) error {
+	return filepath.Walk(w.fullPath(), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			log.Fatalln("[error] Couldn't watch folder:", err)
+			return err
 		}
 
 		if info.IsDir() {
-			w.addWatchDir(path)
+			return w.addWatchDir(path)
 		}
 		return nil
 	})
 }
 
 func (w *DirWatcher) handleNewDir(e *Event) {
-	w.addWatchDir(e.Name())
+	if err := w.addWatchDir(e.Name()); err != nil {
+		w.sendFileToPlugin(NewPseudoEvent("watcher error", ERROR, err))
+	}
 }
 
 func (w *DirWatcher) fullPath() string {
@@ -18,9 +18,8 @@ func (w *FileWatcher) Name() string {
 func (w *FileWatcher) GetAllFiles() int {
 	size := 0
 	for _, name := range w.Files {
-		size++
 		path := filepath.Join(w.Root, w.Dir, name)
-		w.sendFileToPlugin(NewPseudoEvent(path, CREATE))
+		size += w.sendFileToPlugin(NewScanEvent(path))
 	}
 	return size
 }
@@ -34,14 +33,17 @@ func (w *FileWatcher) IsWatchingEvent(evt *Event) bool {
 	return false
 }
 
-func (w *FileWatcher) addWatchDirs() {
+func (w *FileWatcher) addWatchDirs() error {
 	for _, f := range w.Files {
 		name := filepath.Join(w.Dir, f)
 		fullName := filepath.Join(w.Root, name)
 
-		Plog.PrintC("watching", name)
-		w.addWatchDir(filepath.Dir(fullName))
+		w.log.PrintC("watching", name)
+		if err := w.addWatchDir(filepath.Dir(fullName)); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 func (w *FileWatcher) handleNewDir(e *Event) {
@@ -1,22 +1,52 @@
 package adapters
 
 import (
+	"encoding/base64"
 	"os/exec"
 )
 
-const DelimEnd = "__DEVCADDY_END__"
-const DelimJoin = "__DEVCADDY_JOIN__"
+// Adapters talk to devcaddy with newline delimited JSON. Each request is
+// a line on stdin,
+//
+//	{"id": 1, "name": "app/a.js", "content": "..."}
+//
+// answered by a line on stdout with the same id,
+//
+//	{"id": 1, "name": "app/a.js", "content": "...", "map": "...",
+//	 "warnings": [], "errors": [], "logs": []}
+//
+// where "errors" fail the file. What the plugin prints during a call, to
+// stdout or stderr, goes in "logs", and what it reports with its
+// language's warnings, ie. console.warn, Kernel#warn or the warnings
+// module, in "warnings". Anything written to stderr between calls is a
+// log too.
+
+// DelimReady is printed by an adapter once its plugin is loaded. An
+// adapter that can't load it prints why on stderr and exits.
+const DelimReady = "__DEVCADDY_READY__"
 
 var Map = map[string]Adapter{
-	"node": Node,
+	"node":    Node,
+	"ruby":    Ruby,
+	"python3": Python,
 }
 
+// Adapter runs Fn's script with Name and Args. The script loads the
+// plugin module, with its settings as JSON. Path is the absolute path
+// of the module's file, if it was read from one, that the module's
+// relative requires resolve from.
 type Adapter struct {
 	Name string
 	Args []string
-	Fn   func(interface{}, interface{}) string
+	Fn   func(module, settings, path string) string
+}
+
+func (a *Adapter) Cmd(module, settings, path string) *exec.Cmd {
+	return exec.Command(a.Name, append(a.Args, a.Fn(module, settings, path))...)
 }
 
-func (a *Adapter) Cmd(arg1, arg2 interface{}) *exec.Cmd {
-	return exec.Command(a.Name, append(a.Args, a.Fn(arg1, arg2))...)
+// encode makes arg a base64 string, to be decoded by a script whatever
+// its quoting rules.
+func encode(arg string) string {
+	return base64.StdEncoding.EncodeToString([]byte(arg))
 }
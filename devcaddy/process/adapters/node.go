@@ -10,50 +10,176 @@ var Node = Adapter{
 	Fn:   nodeScript,
 }
 
-func nodeScript(arg1, arg2 interface{}) string {
+// nodeScript runs a module that exports plugin(file, settings). The plugin
+// returns its output, a Promise of it, or takes a third callback(err,
+// output) argument. The module is loaded as the file at path, so its
+// requires resolve from there.
+func nodeScript(module, settings, path string) string {
 	return `
 process.stdin.setEncoding('utf8');
 
-var END = "\` + DelimEnd + `";
-var JOIN = "` + DelimJoin + `";
-var buf = [];
-var modStr = "` + template.JSEscapeString(arg1.(string)) + `";
-var settings = "` + template.JSEscapeString(arg2.(string)) + `"
-var m, input, split, res;
+var Module = module.constructor;
+var pathLib = require('path');
+var util = require('util');
+
+var READY = "` + DelimReady + `";
+var modStr = "` + template.JSEscapeString(module) + `";
+var settings = JSON.parse("` + template.JSEscapeString(settings) + `" || "{}");
+var file = "` + template.JSEscapeString(path) + `";
+var buf = '';
+var queue = [];
+var m, current, fail;
+
+// stdout only carries the responses, what the plugin writes there goes to
+// stderr like the console output between requests
+var out = process.stdout.write.bind(process.stdout);
+process.stdout.write = process.stderr.write.bind(process.stderr);
+
+function send(res) {
+    out(JSON.stringify(res) + '\n');
+}
+
+function text(args) {
+    return Array.prototype.map.call(args, function(a) {
+        return typeof a === 'string' ? a : util.inspect(a);
+    }).join(' ');
+}
+
+function describe(e) {
+    return e && (e.stack || e.message) || String(e);
+}
+
+// console output goes in the response of the current request, or to
+// stderr between requests
+function capture(field) {
+    return function() {
+        if (current) {
+            current[field].push(text(arguments));
+        } else {
+            process.stderr.write(text(arguments) + '\n');
+        }
+    };
+}
+console.log = console.info = console.debug = capture('logs');
+console.warn = capture('warnings');
+console.error = capture('logs');
+
+// errors thrown by the plugin's callbacks fail the current request
+function uncaught(e) {
+    if (fail) {
+        fail(e);
+    } else {
+        process.stderr.write(describe(e) + '\n');
+        process.exit(1);
+    }
+}
+process.on('uncaughtException', uncaught);
+process.on('unhandledRejection', uncaught);
 
 if (modStr !== "") {
-    m = new module.constructor();
-    m.paths = module.paths;
-    m._compile(modStr, '__devcaddy_node_plugin__.js');
+    try {
+        var filename = file || pathLib.resolve('__devcaddy_node_plugin__.js');
+        m = new Module(filename, module);
+        m.filename = filename;
+        m.paths = file ? Module._nodeModulePaths(pathLib.dirname(file)) : module.paths;
+        m._compile(modStr, filename);
+
+        if (typeof m.exports.plugin !== 'function') {
+            throw new Error('the plugin module does not export a plugin function');
+        }
+    } catch (e) {
+        process.stderr.write(describe(e) + '\n');
+        process.exit(1);
+    }
+}
+
+out(READY + '\n');
+
+function result(res, out) {
+    if (out !== undefined && out !== null && typeof out !== 'object') {
+        res.content = String(out);
+    } else if (out) {
+        if (out.name) res.name = out.name;
+        if (out.content !== undefined) res.content = String(out.content);
+        if (out.map) res.map = typeof out.map === 'string' ? out.map : JSON.stringify(out.map);
+        res.warnings = res.warnings.concat(out.warnings || []);
+        res.errors = res.errors.concat(out.errors || []);
+    }
+}
+
+// handle answers req once the plugin is done with it, then calls done.
+// Only the first outcome of a request counts.
+function handle(req, done) {
+    var res = { id: req.id, content: '', warnings: [], errors: [], logs: [] };
+    var finished = false;
+
+    function finish(err, out) {
+        if (finished) {
+            return;
+        }
+        finished = true;
+
+        if (err) {
+            res.errors.push(describe(err));
+        } else {
+            result(res, out);
+        }
+        current = fail = null;
+        send(res);
+        done();
+    }
+
+    current = res;
+    fail = finish;
+
+    try {
+        if (!m) {
+            return finish(null, eval(req.content));
+        }
+
+        var plugin = m.exports.plugin;
+        var input = { name: req.name, content: req.content };
+
+        if (plugin.length >= 3) {
+            return plugin(input, settings, finish);
+        }
+
+        var out = plugin(input, settings);
+        if (out && typeof out.then === 'function') {
+            out.then(function(o) {
+                finish(null, o);
+            }, function(e) {
+                finish(e || new Error('the plugin promise was rejected'));
+            });
+        } else {
+            finish(null, out);
+        }
+    } catch (e) {
+        finish(e);
+    }
+}
+
+// requests are handled one at a time, so logs go to the right one
+function next() {
+    if (current || queue.length === 0) {
+        return;
+    }
+    handle(queue.shift(), function() {
+        setImmediate(next);
+    });
 }
 
 process.stdin.on('data', function(chunk) {
-    chunk = chunk.trim();
-
-    if(chunk.indexOf(END) > 0) {
-        var _ch = chunk.replace(END, '');
-        buf.push(_ch);
-        input = buf.join('\n');
-        buf = [];
-
-        try {
-            if(m) {
-                if (!settings) {
-                    settings = "{}";
-                }
-
-                split = input.split(JOIN);
-                input = { name: split[0], content: split[1] };
-                res = m.exports.plugin(input, JSON.parse(settings));
-                console.log(JSON.stringify(res));
-            } else {
-                eval(input);
-            }
-        } catch (e) {
-            process.stderr.write(e.message + '\n' + e.stack + '\n');
+    buf += chunk;
+
+    var i;
+    while ((i = buf.indexOf('\n')) >= 0) {
+        var line = buf.slice(0, i);
+        buf = buf.slice(i + 1);
+        if (line.trim() !== '') {
+            queue.push(JSON.parse(line));
         }
-    } else {
-        buf.push(chunk);
     }
+    next();
 });`
 }
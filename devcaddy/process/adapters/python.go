@@ -0,0 +1,79 @@
+package adapters
+
+var Python = Adapter{
+	Name: "python3",
+	Args: []string{"-c"},
+	Fn:   pythonScript,
+}
+
+// pythonScript runs a plugin file that defines plugin(file, settings).
+// What it prints to stdout or stderr goes in the logs, what it warns with
+// the warnings module in the warnings, and what it prints outside of a
+// call to stderr. The
+// modules next to path can be imported.
+func pythonScript(module, settings, path string) string {
+	return `
+import base64, io, json, os, sys, traceback, warnings
+
+out = io.open(os.dup(1), 'w', encoding='utf-8', newline='\n', buffering=1)
+os.dup2(2, 1)
+sys.stdout = stderr = sys.stderr
+stdin = io.TextIOWrapper(sys.stdin.buffer, encoding='utf-8')
+
+mod = base64.b64decode("` + encode(module) + `").decode('utf-8')
+settings = base64.b64decode("` + encode(settings) + `").decode('utf-8')
+path = base64.b64decode("` + encode(path) + `").decode('utf-8')
+ns = {'__name__': '__devcaddy_plugin__'}
+if path:
+    ns['__file__'] = path
+    sys.path.insert(0, os.path.dirname(path))
+
+try:
+    settings = json.loads(settings or '{}')
+    exec(compile(mod, path or '__devcaddy_python_plugin__.py', 'exec'), ns)
+
+    if not callable(ns.get('plugin')):
+        raise Exception('the plugin file does not define a plugin function')
+except Exception:
+    stderr.write(traceback.format_exc())
+    sys.exit(1)
+
+out.write('` + DelimReady + `\n')
+
+while True:
+    line = stdin.readline()
+    if not line:
+        break
+    if not line.strip():
+        continue
+
+    req = json.loads(line)
+    res = {'id': req['id'], 'content': '', 'warnings': [], 'errors': [], 'logs': []}
+    logs = io.StringIO()
+    sys.stdout = sys.stderr = logs
+
+    try:
+        with warnings.catch_warnings(record=True) as caught:
+            warnings.simplefilter('always')
+            o = ns['plugin']({'name': req['name'], 'content': req['content']}, settings)
+        res['warnings'] += [str(w.message) for w in caught]
+
+        if isinstance(o, dict):
+            if o.get('name'):
+                res['name'] = o['name']
+            if o.get('content') is not None:
+                res['content'] = str(o['content'])
+            if o.get('map'):
+                res['map'] = o['map'] if isinstance(o['map'], str) else json.dumps(o['map'])
+            res['warnings'] += [str(w) for w in o.get('warnings') or []]
+            res['errors'] += [str(e) for e in o.get('errors') or []]
+        elif o is not None:
+            res['content'] = str(o)
+    except Exception:
+        res['errors'].append(traceback.format_exc())
+    finally:
+        sys.stdout = sys.stderr = stderr
+
+    res['logs'] += [l for l in logs.getvalue().splitlines() if l.strip()]
+    out.write(json.dumps(res) + '\n')`
+}
@@ -0,0 +1,90 @@
+package adapters
+
+var Ruby = Adapter{
+	Name: "ruby",
+	Args: []string{"-e"},
+	Fn:   rubyScript,
+}
+
+// rubyScript runs a plugin file that defines plugin(file, settings). What
+// it prints to stdout or stderr goes in the logs, what it warns in the
+// warnings, and what it prints outside of a call to stderr. It is
+// evaluated as path, for require_relative.
+func rubyScript(module, settings, path string) string {
+	return `
+require 'json'
+require 'stringio'
+
+OUT = STDOUT.dup
+OUT.sync = true
+STDOUT.reopen(STDERR)
+STDIN.set_encoding('UTF-8')
+
+# Kernel#warn goes through Warning.warn, to the current call's warnings
+$warns = nil
+module Warning
+  def self.warn(msg, *, **)
+    ($warns || STDERR).write(msg)
+  end
+end
+
+def lines(io)
+  io.string.lines.map(&:chomp).reject { |l| l.strip.empty? }
+end
+
+def describe(e)
+  ([e.class.to_s + ': ' + e.message] + (e.backtrace || [])).join("\n")
+end
+
+mod = "` + encode(module) + `".unpack('m').first.force_encoding('UTF-8')
+settings = "` + encode(settings) + `".unpack('m').first.force_encoding('UTF-8')
+path = "` + encode(path) + `".unpack('m').first.force_encoding('UTF-8')
+
+begin
+  settings = JSON.parse(settings.empty? ? '{}' : settings)
+  m = Object.new
+  m.instance_eval(mod, path.empty? ? '__devcaddy_ruby_plugin__.rb' : path)
+
+  unless m.respond_to?(:plugin)
+    raise 'the plugin file does not define a plugin method'
+  end
+rescue StandardError, ScriptError => e
+  STDERR.puts(describe(e))
+  exit 1
+end
+
+OUT.puts('` + DelimReady + `')
+
+while (line = STDIN.gets)
+  next if line.strip.empty?
+
+  req = JSON.parse(line)
+  res = { 'id' => req['id'], 'content' => '', 'warnings' => [], 'errors' => [], 'logs' => [] }
+  logs = StringIO.new
+  warns = $warns = StringIO.new
+  $stdout = $stderr = logs
+
+  begin
+    out = m.plugin({ 'name' => req['name'], 'content' => req['content'] }, settings)
+
+    if out.is_a?(Hash)
+      out = Hash[out.map { |k, v| [k.to_s, v] }]
+      res['name'] = out['name'] if out['name']
+      res['content'] = out['content'].to_s unless out['content'].nil?
+      res['map'] = out['map'].is_a?(String) ? out['map'] : JSON.generate(out['map']) if out['map']
+      res['warnings'] += Array(out['warnings']).map(&:to_s)
+      res['errors'] += Array(out['errors']).map(&:to_s)
+    elsif !out.nil?
+      res['content'] = out.to_s
+    end
+  rescue StandardError, ScriptError => e
+    res['errors'] << describe(e)
+  ensure
+    $stdout, $stderr, $warns = STDOUT, STDERR, nil
+  end
+
+  res['logs'] += lines(logs)
+  res['warnings'] += lines(warns)
+  OUT.puts(JSON.generate(res))
+end`
+}
@@ -2,128 +2,358 @@ package process
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/monocle/devcaddy/devcaddy/process/adapters"
 )
 
-func logFatal(msg string, err error) {
-	if err != nil {
-		log.Fatal(msg+" error:", err)
-	}
+// Request is a file sent to an adapter. Its ID is set by the process.
+type Request struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
 }
 
-func Marshal(args ...string) string {
-	return strings.Join(args, adapters.DelimJoin)
+func NewRequest(name, content string) *Request {
+	return &Request{Name: name, Content: content}
 }
 
+// Output is the response of an adapter to a Request.
 type Output struct {
-	Content string
-	Error   error
+	ID       int      `json:"id"`
+	Name     string   `json:"name"`
+	Content  string   `json:"content"`
+	Map      string   `json:"map"`
+	Warnings []string `json:"warnings"`
+	Errors   []string `json:"errors"`
+	Logs     []string `json:"logs"`
+
+	// Error joins the Errors, which fail the request.
+	Error error `json:"-"`
 }
 
-func NewProcess(name, arg1, arg2 string) *Process {
-	adapter := adapters.Map[name]
-	cmd := adapter.Cmd(arg1, arg2)
-	delim := adapters.DelimEnd
+// HasAdapter is true if plugins run by command can run in a process.
+func HasAdapter(command string) bool {
+	_, ok := adapters.Map[command]
+	return ok
+}
 
-	in, err := cmd.StdinPipe()
-	logFatal("stdin pipe", err)
+// START_TIMEOUT is how long a process has to load its plugin and report
+// that it is ready.
+const START_TIMEOUT = 10 * time.Second
 
-	out, err := cmd.StdoutPipe()
-	logFatal("stdout pipe", err)
-	outBuf := bufio.NewReader(out)
+// NewProcess starts the adapter for name with the plugin module arg1 and
+// its settings arg2. It returns once the process reported it is ready,
+// or with an error holding what the process printed if it failed to.
+func NewProcess(name, arg1, arg2 string) (*Process, error) {
+	return NewPluginProcess(name, "", arg1, arg2)
+}
 
+// NewPluginProcess is NewProcess for a module read from the file at
+// path. The module is loaded as that file, so its relative requires
+// resolve from its dir.
+func NewPluginProcess(name, path, module, settings string) (*Process, error) {
+	adapter, ok := adapters.Map[name]
+	if !ok {
+		return nil, fmt.Errorf("no process adapter for %q", name)
+	}
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		path = abs
+	}
+	cmd := adapter.Cmd(module, settings, path)
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
 	e, err := cmd.StderrPipe()
-	logFatal("stderr pipe", err)
-	errBuf := bufio.NewReader(e)
+	if err != nil {
+		return nil, err
+	}
 
-	err = cmd.Start()
-	logFatal("command start", err)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &Process{
+		cmd:     cmd,
+		In:      make(chan *Request),
+		Out:     make(chan *Output),
+		inPipe:  in,
+		pipes:   []io.Closer{out, e},
+		outBuf:  bufio.NewReader(out),
+		errBuf:  bufio.NewReader(e),
+		res:     make(chan *Output),
+		logs:    make(chan string),
+		quit:    make(chan bool),
+		exited:  make(chan bool),
+		started: time.Now(),
+	}
+	go p.wait()
 
-	p := Process{
-		Delim:  delim,
-		In:     make(chan string),
-		Out:    make(chan *Output),
-		inPipe: in,
-		outBuf: outBuf,
-		errBuf: errBuf,
-		res:    make(chan *Output),
+	if err := p.handshake(); err != nil {
+		return nil, err
 	}
 
 	go p.listenIn()
-	return &p
+	go p.listenOutBuf()
+	go p.listenErrBuf()
+	return p, nil
 }
 
 type Process struct {
-	Delim  string
-	In     chan string
-	Out    chan *Output
-	inPipe io.WriteCloser
-	outBuf *bufio.Reader
-	errBuf *bufio.Reader
-	res    chan *Output
+	cmd     *exec.Cmd
+	In      chan *Request
+	Out     chan *Output
+	inPipe  io.WriteCloser
+	pipes   []io.Closer
+	outBuf  *bufio.Reader
+	errBuf  *bufio.Reader
+	res     chan *Output // responses read from stdout
+	logs    chan string  // other lines, from stdout or stderr
+	quit    chan bool
+	once    sync.Once
+	started time.Time
+	lastID  int // of the latest request, only used by listenIn
+
+	// exited is closed once the process has exited, with state and err
+	// set by wait
+	exited chan bool
+	state  *os.ProcessState
+	err    error
 }
 
-func (p *Process) listenIn() {
-	for {
-		in := <-p.In
+// wait notes when the process exits. The pipes are left open so what
+// it printed can still be read.
+func (p *Process) wait() {
+	p.state, p.err = p.cmd.Process.Wait()
+	close(p.exited)
+}
+
+// handshake waits for the adapter to print DelimReady once the plugin is
+// loaded. Anything else means it failed, and what it printed on stderr
+// is the reason.
+func (p *Process) handshake() error {
+	line := make(chan string, 1)
+	go func() {
+		s, _ := p.outBuf.ReadString('\n')
+		line <- strings.TrimSpace(s)
+	}()
 
-		_, err := p.inPipe.Write([]byte(in + p.Delim + "\n"))
-		logFatal("inPipe write", err)
+	select {
+	case s := <-line:
+		if s == adapters.DelimReady {
+			return nil
+		}
+	case <-time.After(START_TIMEOUT):
+		p.Kill()
+		return fmt.Errorf("the plugin process did not start within %s", START_TIMEOUT)
+	}
 
-		go p.listenOutBuf()
-		go p.listenErrBuf()
+	p.cmd.Process.Kill()
+	<-p.exited
+	b, _ := ioutil.ReadAll(p.errBuf)
+	p.Kill()
 
-		// block to avoid weird node error if multiple inputs
-		// come in before first input is processed
-		out := <-p.res
-		p.Out <- out
+	msg := strings.TrimSpace(string(b))
+	if msg == "" {
+		msg = "no error was printed"
 	}
+	return fmt.Errorf("the plugin process failed to start: %s", msg)
 }
 
-func (p *Process) listenOutBuf() {
-	str := p.readFromBuf(p.outBuf)
-	if str != "" {
-		res := &Output{Content: str}
-		p.res <- res
-	}
+// CLOSE_TIMEOUT is how long a process has to exit after its stdin is
+// closed before it is killed.
+const CLOSE_TIMEOUT = 2 * time.Second
+
+// Close asks the process to exit by closing its stdin, then kills it if
+// it is still running after CLOSE_TIMEOUT. It returns once the process
+// has exited.
+func (p *Process) Close() error {
+	return p.stop(CLOSE_TIMEOUT)
 }
 
-func (p *Process) listenErrBuf() {
-	str := p.readFromBuf(p.errBuf)
-	if str != "" {
-		res := &Output{Error: errors.New(str)}
-		p.res <- res
+// Kill ends the process right away.
+func (p *Process) Kill() error {
+	return p.stop(0)
+}
+
+func (p *Process) stop(grace time.Duration) error {
+	var err error
+
+	p.once.Do(func() {
+		close(p.quit)
+		err = p.inPipe.Close()
+
+		select {
+		case <-p.exited:
+		case <-time.After(grace):
+			p.cmd.Process.Kill()
+			<-p.exited
+		}
+
+		for _, pipe := range p.pipes {
+			pipe.Close()
+		}
+	})
+	return err
+}
+
+// Closed is closed once Close is called. Inputs are not read and no
+// outputs are sent afterwards.
+func (p *Process) Closed() <-chan bool {
+	return p.quit
+}
+
+// Exited is closed once the process has exited, whether it was closed or
+// crashed.
+func (p *Process) Exited() <-chan bool {
+	return p.exited
+}
+
+// ExitError describes how the process exited, once Exited is closed.
+func (p *Process) ExitError() error {
+	select {
+	case <-p.exited:
+	default:
+		return nil
+	}
+
+	if p.err != nil {
+		return p.err
 	}
+	return errors.New("the plugin process exited: " + p.state.String())
 }
 
-func (p *Process) readFromBuf(buf *bufio.Reader) string {
-	res := []string{}
+// Uptime is how long the process has been running.
+func (p *Process) Uptime() time.Duration {
+	return time.Since(p.started)
+}
 
+func (p *Process) listenIn() {
+	logs := []string{} // printed between requests
 	for {
-		out, err := buf.ReadString('\n')
-		if err != nil && err != io.EOF {
-			logFatal("Output buffer read", err)
-			return ""
+		var req *Request
+		select {
+		case req = <-p.In:
+		case line := <-p.logs:
+			logs = append(logs, line)
+			continue
+		case <-p.quit:
+			return
+		case <-p.exited:
+			return
 		}
 
-		remaining := buf.Buffered()
+		p.lastID++
+		r := *req
+		r.ID = p.lastID
+		b, _ := json.Marshal(&r)
 
-		if err == io.EOF && remaining == 0 {
-			return ""
+		// a write error means the process is gone, which exited tells
+		if _, err := p.inPipe.Write(append(b, '\n')); err != nil {
+			return
 		}
 
-		trim := strings.TrimSpace(out)
-		if trim != "" {
-			res = append(res, trim)
+		// one request at a time, an adapter answers them in order
+		out, ok := p.response(r.ID, logs)
+		if !ok {
+			return
 		}
+		logs = []string{}
+		p.send(p.Out, out)
+	}
+}
 
-		if remaining == 0 && len(res) > 0 {
-			return strings.Join(res, "\n")
+// response waits for the output of request id, adding the lines logged
+// meanwhile to logs. Outputs of earlier requests are dropped.
+func (p *Process) response(id int, logs []string) (*Output, bool) {
+	for {
+		select {
+		case out := <-p.res:
+			if out.ID != id {
+				continue
+			}
+			out.Logs = append(logs, out.Logs...)
+			if len(out.Errors) > 0 {
+				out.Error = errors.New(strings.Join(out.Errors, "\n"))
+			}
+			return out, true
+		case line := <-p.logs:
+			logs = append(logs, line)
+		case <-p.quit:
+			return nil, false
+		case <-p.exited:
+			return nil, false
 		}
 	}
 }
+
+// listenOutBuf reads a response per line. Lines that aren't one were
+// printed by the plugin, they are logs.
+func (p *Process) listenOutBuf() {
+	for {
+		line, err := p.outBuf.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		out := &Output{}
+		if json.Unmarshal([]byte(line), out) != nil || out.ID == 0 {
+			p.log(line)
+			continue
+		}
+		p.send(p.res, out)
+	}
+}
+
+// listenErrBuf reads the lines printed on stderr, as logs.
+func (p *Process) listenErrBuf() {
+	for {
+		line, err := p.errBuf.ReadString('\n')
+		if err != nil {
+			return
+		}
+		p.log(line)
+	}
+}
+
+func (p *Process) log(line string) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+
+	select {
+	case p.logs <- line:
+	case <-p.quit:
+	case <-p.exited:
+	}
+}
+
+// send gives up on out when the process is closed or exited.
+func (p *Process) send(ch chan *Output, out *Output) {
+	select {
+	case ch <- out:
+	case <-p.quit:
+	case <-p.exited:
+	}
+}
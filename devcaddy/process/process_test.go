@@ -1,35 +1,56 @@
 package process
 
 import (
-	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 )
 
 func TestProcess(t *testing.T) {
 	Convey("Node process", t, func() {
-		p := NewProcess("node", "", "")
+		p, err := NewProcess("node", "", "")
+		So(err, ShouldBeNil)
+		defer p.Kill()
 
 		Convey("Can process multiple inputs", func() {
-			p.In <- `console.log('foo');`
+			p.In <- NewRequest("", `"foo"`)
 			res := <-p.Out
 			So(res.Error, ShouldBeNil)
 			So(res.Content, ShouldEqual, "foo")
 
-			p.In <- `console.log("bar\nfoo\n");`
+			p.In <- NewRequest("", `"bar\nfoo\n"`)
 			res = <-p.Out
 			So(res.Error, ShouldBeNil)
-			So(res.Content, ShouldEqual, "bar\nfoo")
+			So(res.Content, ShouldEqual, "bar\nfoo\n")
+		})
+
+		Convey("Keeps what is printed apart from the content", func() {
+			p.In <- NewRequest("", `console.log("foo"); console.warn("careful"); "bar"`)
+			res := <-p.Out
+			So(res.Error, ShouldBeNil)
+			So(res.Content, ShouldEqual, "bar")
+			So(res.Logs, ShouldResemble, []string{"foo"})
+			So(res.Warnings, ShouldResemble, []string{"careful"})
+
+			p.In <- NewRequest("", `process.stderr.write("to stderr\n"); "baz"`)
+			res = <-p.Out
+			So(res.Error, ShouldBeNil)
+			So(res.Content, ShouldEqual, "baz")
 		})
 
 		Convey("Can process errors", func() {
-			p.In <- "asfd"
+			p.In <- NewRequest("", "asfd")
 			res := <-p.Out
 			So(res.Content, ShouldEqual, "")
 			So(res.Error.Error(), ShouldContainSubstring, "asfd is not defined")
 
-			p.In <- "zzz"
+			p.In <- NewRequest("", "zzz")
 			res = <-p.Out
 			So(res.Content, ShouldEqual, "")
 			So(res.Error.Error(), ShouldContainSubstring, "zzz is not defined")
@@ -45,22 +66,296 @@ func TestProcess(t *testing.T) {
 
 			settings := `{ "connector": "!" }`
 
-			p := NewProcess("node", testNodeModule, settings)
-			p.In <- Marshal("foo.js", `bar . \
+			p, err := NewProcess("node", testNodeModule, settings)
+			So(err, ShouldBeNil)
+			defer p.Kill()
+			p.In <- NewRequest("foo.js", `bar . \
 baz`)
 			res := <-p.Out
 
 			So(res.Error, ShouldBeNil)
+			So(res.Name, ShouldEqual, "foo.js!")
+			So(res.Content, ShouldEqual, `bar . \
+baz!`)
+		})
+	})
+}
 
-			var jsn map[string]string
-			err := json.Unmarshal([]byte(res.Content), &jsn)
-			if err != nil {
-				t.Fatal(err)
-			}
+func TestProcessClose(t *testing.T) {
+	Convey("Closing a process ends it", t, func() {
+		p, err := NewProcess("node", "", "")
+		So(err, ShouldBeNil)
+		p.In <- NewRequest("", "1")
+		<-p.Out
 
-			So(jsn["name"], ShouldEqual, "foo.js!")
-			So(jsn["content"], ShouldEqual, `bar . \
-baz!`)
+		// a process killed after CLOSE_TIMEOUT would not have exited
+		So(p.Close(), ShouldBeNil)
+		So(p.state.Exited(), ShouldBeTrue)
+
+		select {
+		case <-p.Closed():
+		default:
+			So("Closed should be closed", ShouldBeBlank)
+		}
+	})
+
+	Convey("A process that doesn't exit when its stdin is closed is killed", t, func() {
+		p, err := NewProcess("node", "setInterval(function() {}, 1000); exports.plugin = function() {};", "")
+		So(err, ShouldBeNil)
+
+		start := time.Now()
+		p.Close()
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, CLOSE_TIMEOUT)
+		So(p.state.Exited(), ShouldBeFalse)
+	})
+}
+
+func TestProcessStart(t *testing.T) {
+	Convey("A module that fails to load is a start error", t, func() {
+		p, err := NewProcess("node", "throw new Error('broken plugin');", "")
+
+		So(p, ShouldBeNil)
+		So(err.Error(), ShouldStartWith, "the plugin process failed to start: ")
+		So(err.Error(), ShouldContainSubstring, "broken plugin")
+	})
+
+	Convey("A module without a plugin function is a start error", t, func() {
+		_, err := NewProcess("node", "exports.foo = 1;", "")
+
+		So(err.Error(), ShouldContainSubstring, "does not export a plugin function")
+	})
+
+	Convey("An unknown adapter is an error", t, func() {
+		_, err := NewProcess("cobol", "", "")
+
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("A process that crashes is reported as exited", t, func() {
+		p, err := NewProcess("node", "exports.plugin = function() { process.exit(3); };", "")
+		So(err, ShouldBeNil)
+		defer p.Kill()
+
+		So(p.ExitError(), ShouldBeNil)
+		p.In <- NewRequest("foo.js", "")
+		<-p.Exited()
+
+		So(p.ExitError().Error(), ShouldEqual, "the plugin process exited: exit status 3")
+	})
+}
+
+func TestProtocol(t *testing.T) {
+	Convey("Outputs carry the ID of their request", t, func() {
+		p, err := NewProcess("node", "exports.plugin = function(file) { return file.name; };", "")
+		So(err, ShouldBeNil)
+		defer p.Kill()
+
+		p.In <- NewRequest("a.js", "")
+		res := <-p.Out
+		So(res.ID, ShouldEqual, 1)
+		So(res.Content, ShouldEqual, "a.js")
+
+		p.In <- NewRequest("b.js", "")
+		res = <-p.Out
+		So(res.ID, ShouldEqual, 2)
+		So(res.Content, ShouldEqual, "b.js")
+	})
+
+	Convey("Any content can be sent", t, func() {
+		p, err := NewProcess("node", "exports.plugin = function(file) { return file.content; };", "")
+		So(err, ShouldBeNil)
+		defer p.Kill()
+
+		content := "__DEVCADDY_END__\n__DEVCADDY_JOIN__\n\n" + strings.Repeat("long line ", 100000)
+		p.In <- NewRequest("a.js", content)
+		res := <-p.Out
+		So(res.Error, ShouldBeNil)
+		So(res.Content, ShouldEqual, content)
+	})
+
+	Convey("Plugins return warnings and errors", t, func() {
+		p, err := NewProcess("node", `exports.plugin = function(file) {
+			return { content: "partial", warnings: ["unused var"], errors: ["bad syntax", "bad name"] };
+		};`, "")
+		So(err, ShouldBeNil)
+		defer p.Kill()
+
+		p.In <- NewRequest("a.js", "")
+		res := <-p.Out
+		So(res.Content, ShouldEqual, "partial")
+		So(res.Warnings, ShouldResemble, []string{"unused var"})
+		So(res.Error.Error(), ShouldEqual, "bad syntax\nbad name")
+	})
+
+	Convey("Plugins writing to stdout don't break the responses", t, func() {
+		p, err := NewProcess("node", `exports.plugin = function(file) {
+			process.stdout.write("no newline");
+			return file.name;
+		};`, "")
+		So(err, ShouldBeNil)
+		defer p.Kill()
+
+		p.In <- NewRequest("a.js", "")
+		res := <-p.Out
+		So(res.Content, ShouldEqual, "a.js")
+
+		p.In <- NewRequest("b.js", "")
+		res = <-p.Out
+		So(res.Content, ShouldEqual, "b.js")
+	})
+}
+
+func TestAdapters(t *testing.T) {
+	modules := map[string]string{
+		"ruby": `
+puts "loading"
+system "echo loaded"
+
+def plugin(file, settings)
+  @count = (@count || 0) + 1
+  puts "call #{@count}"
+  $stderr.puts "to stderr"
+  warn "careful"
+  raise "bad file" if file['name'] == 'bad.rb'
+  { name: file['name'] + settings['connector'], content: file['content'] + @count.to_s }
+end
+`,
+		"python3": `
+import os, sys, warnings
+print("loading")
+os.system("echo loaded")
+count = 0
+
+def plugin(file, settings):
+    global count
+    count += 1
+    print("call %d" % count)
+    sys.stderr.write("to stderr\n")
+    warnings.warn("careful")
+    if file['name'] == 'bad.rb':
+        raise Exception("bad file")
+    return {'name': file['name'] + settings['connector'], 'content': file['content'] + str(count)}
+`,
+	}
+
+	for name, module := range modules {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Logf("skipping the %s adapter, %s is not installed", name, name)
+			continue
+		}
+
+		Convey("The "+name+" adapter runs a plugin function", t, func() {
+			p, err := NewProcess(name, module, `{"connector": "!"}`)
+			So(err, ShouldBeNil)
+			defer p.Kill()
+
+			p.In <- NewRequest("a.rb", "héllo __DEVCADDY_END__\n")
+			res := <-p.Out
+			So(res.Error, ShouldBeNil)
+			So(res.Name, ShouldEqual, "a.rb!")
+			So(res.Content, ShouldEqual, "héllo __DEVCADDY_END__\n1")
+			So(res.Logs, ShouldContain, "call 1")
+			So(res.Logs, ShouldContain, "to stderr")
+			So(res.Warnings, ShouldHaveLength, 1)
+			So(res.Warnings[0], ShouldContainSubstring, "careful")
+
+			p.In <- NewRequest("bad.rb", "")
+			res = <-p.Out
+			So(res.Error.Error(), ShouldContainSubstring, "bad file")
+
+			p.In <- NewRequest("b.rb", "")
+			res = <-p.Out
+			So(res.Content, ShouldEqual, "3")
 		})
+
+		Convey("The "+name+" adapter reports a plugin that fails to load", t, func() {
+			_, err := NewProcess(name, "x = ", "")
+			So(err.Error(), ShouldStartWith, "the plugin process failed to start: ")
+
+			_, err = NewProcess(name, "", "")
+			So(err.Error(), ShouldContainSubstring, "does not define a plugin")
+		})
+	}
+}
+
+func TestNodeAsyncPlugins(t *testing.T) {
+	Convey("A plugin may return a Promise", t, func() {
+		p, err := NewProcess("node", `exports.plugin = function(file) {
+			return new Promise(function(resolve, reject) {
+				setTimeout(function() {
+					console.log("resolving");
+					if (file.name === "bad.js") {
+						return reject(new Error("rejected " + file.name));
+					}
+					resolve({ name: file.name, content: file.content + "!" });
+				}, 10);
+			});
+		};`, "")
+		So(err, ShouldBeNil)
+		defer p.Kill()
+
+		p.In <- NewRequest("a.js", "a")
+		res := <-p.Out
+		So(res.Error, ShouldBeNil)
+		So(res.Content, ShouldEqual, "a!")
+		So(res.Logs, ShouldResemble, []string{"resolving"})
+
+		p.In <- NewRequest("bad.js", "")
+		res = <-p.Out
+		So(res.Error.Error(), ShouldContainSubstring, "rejected bad.js")
+	})
+
+	Convey("A plugin may take a callback", t, func() {
+		p, err := NewProcess("node", `exports.plugin = function(file, settings, done) {
+			setTimeout(function() {
+				if (file.name === "bad.js") {
+					return done(new Error("failed " + file.name));
+				}
+				if (file.name === "throw.js") {
+					throw new Error("thrown in a callback");
+				}
+				done(null, file.content + settings.suffix);
+			}, 10);
+		};`, `{"suffix": "?"}`)
+		So(err, ShouldBeNil)
+		defer p.Kill()
+
+		p.In <- NewRequest("a.js", "a")
+		res := <-p.Out
+		So(res.Error, ShouldBeNil)
+		So(res.Content, ShouldEqual, "a?")
+
+		p.In <- NewRequest("bad.js", "")
+		res = <-p.Out
+		So(res.Error.Error(), ShouldContainSubstring, "failed bad.js")
+
+		p.In <- NewRequest("throw.js", "")
+		res = <-p.Out
+		So(res.Error.Error(), ShouldContainSubstring, "thrown in a callback")
+
+		p.In <- NewRequest("b.js", "b")
+		res = <-p.Out
+		So(res.Error, ShouldBeNil)
+		So(res.Content, ShouldEqual, "b?")
+	})
+
+	Convey("Relative requires resolve from the plugin file's dir", t, func() {
+		dir, err := ioutil.TempDir("", "devcaddy-plugin")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		module := `var helper = require("./lib/helper");
+		exports.plugin = function(file) { return helper(file.content); };`
+		So(os.MkdirAll(filepath.Join(dir, "lib"), 0755), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "lib", "helper.js"), []byte(`module.exports = function(s) { return s + " helped"; };`), 0644), ShouldBeNil)
+
+		p, err := NewPluginProcess("node", filepath.Join(dir, "plugin.js"), module, "")
+		So(err, ShouldBeNil)
+		defer p.Kill()
+
+		p.In <- NewRequest("a.js", "a")
+		res := <-p.Out
+		So(res.Error, ShouldBeNil)
+		So(res.Content, ShouldEqual, "a helped")
 	})
 }